@@ -1,9 +1,13 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
 	"linkzipper/internal"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/sirupsen/logrus"
@@ -17,6 +21,61 @@ func main() {
 		cfg.Limits.MaxFilesPerTask,
 		cfg.Limits.AllowedExts,
 	)
+	defaults := internal.TaskOptions{
+		Format:      internal.ArchiveFormat(cfg.Archive.Format),
+		Compression: internal.Compression(cfg.Archive.Compression),
+		Level:       cfg.Archive.Level,
+		AutoStore:   &cfg.Archive.AutoStore,
+	}
+	if err := defaults.Validate(); err != nil {
+		internal.Logger.Fatalf("Invalid archive config: %v", err)
+	}
+	mgr.SetDefaults(defaults)
+	mgr.SetStoreExtensions(cfg.Archive.StoreExtensions)
+	mgr.SetKeepVersions(cfg.Archive.KeepVersions)
+	mgr.SetExtractLimits(internal.ExtractLimits{
+		MaxRatio:     cfg.Archive.Extract.MaxRatio,
+		MaxTotalSize: cfg.Archive.Extract.MaxTotalSize,
+		MaxEntries:   cfg.Archive.Extract.MaxEntries,
+	})
+	keyring, err := internal.LoadKeyRing(cfg.Storage)
+	if err != nil {
+		internal.Logger.Fatalf("Invalid storage config: %v", err)
+	}
+	mgr.SetKeyRing(keyring)
+	signer, err := internal.LoadSigner(cfg.Signing)
+	if err != nil {
+		internal.Logger.Fatalf("Invalid signing config: %v", err)
+	}
+	mgr.SetSigner(signer)
+
+	// разовые команды не должны запускать сервисы сервера, например
+	// досылать вебхуки из очереди
+	if len(os.Args) > 1 && os.Args[1] == "rekey" {
+		rekey(keyring)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verify(signer)
+		return
+	}
+
+	webhooks, err := internal.LoadWebhooks(cfg.Webhooks)
+	if err != nil {
+		internal.Logger.Fatalf("Invalid webhooks config: %v", err)
+	}
+	mgr.SetWebhooks(webhooks)
+	if cfg.Scan.Clamd != "" {
+		policy, err := internal.ParseScanPolicy(cfg.Scan.Policy)
+		if err != nil {
+			internal.Logger.Fatalf("Invalid scan config: %v", err)
+		}
+		scanner, err := internal.NewClamdScanner(cfg.Scan.Clamd, time.Duration(cfg.Scan.Timeout)*time.Second)
+		if err != nil {
+			internal.Logger.Fatalf("Invalid scan config: %v", err)
+		}
+		mgr.SetScanner(scanner, policy, cfg.Scan.QuarantineDir)
+	}
 	api := &internal.API{Manager: mgr}
 
 	r := chi.NewRouter()
@@ -30,15 +89,18 @@ func main() {
 			next.ServeHTTP(w, r)
 		})
 	})
-	r.Post("/tasks", api.CreateTask)
-	r.Post("/tasks/links", api.AddLink)
-	r.Post("/tasks/zip", api.ForceZip)
-
-	r.Get("/tasks/status/*", api.GetStatus)
-	r.Get("/download/*", api.Download)
-	r.Get("/tasks/list", api.ListTasks)
-
-	r.Delete("/tasks/delete/*", api.DeleteTask)
+	if cfg.Server.ValidateRequests {
+		doc, err := internal.LoadSpec()
+		if err != nil {
+			internal.Logger.Fatalf("Invalid OpenAPI spec: %v", err)
+		}
+		validate, err := internal.ValidateRequests(doc)
+		if err != nil {
+			internal.Logger.Fatalf("Invalid OpenAPI spec: %v", err)
+		}
+		r.Use(validate)
+	}
+	api.Mount(r)
 
 	r.Mount("/static/",
 		http.StripPrefix("/static/",
@@ -54,3 +116,58 @@ func main() {
 		internal.Logger.Fatal(http.ListenAndServe(addr, r))
 	}
 }
+
+// rekey перешифровывает архивы во временном каталоге (или в каталоге из
+// второго аргумента) активным ключом хранения
+func rekey(keyring *internal.KeyRing) {
+	if keyring == nil {
+		internal.Logger.Fatal("storage.activeKey is not configured")
+	}
+	dir := os.TempDir()
+	if len(os.Args) > 2 {
+		dir = os.Args[2]
+	}
+	count, err := keyring.RekeyDir(dir)
+	if err != nil {
+		internal.Logger.Fatalf("Rekey failed: %v", err)
+	}
+	internal.Logger.Infof("Re-encrypted %d archives in %s", count, dir)
+}
+
+// verify проверяет архив по отделённой подписи: verify <архив> <подпись> [открытый ключ].
+// Без открытого ключа в base64 используется ключ подписи из конфига
+func verify(signer *internal.Signer) {
+	if len(os.Args) < 4 {
+		internal.Logger.Fatal("usage: verify <archive> <signature> [public key]")
+	}
+	var pub ed25519.PublicKey
+	if len(os.Args) > 4 {
+		key, err := base64.StdEncoding.DecodeString(os.Args[4])
+		if err != nil {
+			internal.Logger.Fatalf("Invalid public key: %v", err)
+		}
+		pub = key
+	} else if signer != nil {
+		pub = signer.PublicKey()
+	} else {
+		internal.Logger.Fatal("signing.key is not configured and no public key given")
+	}
+	sigData, err := os.ReadFile(os.Args[3])
+	if err != nil {
+		internal.Logger.Fatalf("Read signature: %v", err)
+	}
+	sig, err := internal.DecodeSignature(string(sigData))
+	if err != nil {
+		internal.Logger.Fatalf("Read signature: %v", err)
+	}
+	f, err := os.Open(os.Args[2])
+	if err != nil {
+		internal.Logger.Fatalf("Open archive: %v", err)
+	}
+	defer f.Close()
+	digest, err := internal.VerifyArchive(pub, f, sig)
+	if err != nil {
+		internal.Logger.Fatalf("Verification failed for sha256 %x: %v", digest, err)
+	}
+	internal.Logger.Infof("Signature OK, sha256 %x", digest)
+}
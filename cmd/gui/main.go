@@ -16,12 +16,16 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
+// Task — элемент ответа GET /api/v1/tasks, схема TaskSummary в /openapi.json.
+// ArchiveURL приходит только в статусе задачи и заполняется отдельно
 type Task struct {
 	ID         string            `json:"id"`
 	Status     string            `json:"status"`
 	Errors     map[string]string `json:"errors"`
 	Urls       []string          `json:"urls"`
 	ArchiveURL string            `json:"archive_url"`
+	Progress   float64           `json:"progress"`
+	Format     string            `json:"format"`
 }
 
 func main() {
@@ -83,7 +87,7 @@ func main() {
 	var updateDetails func()
 	var refreshStatus func()
 	refreshTasks := func() {
-		resp, err := http.Get(serverURL + "/tasks/list")
+		resp, err := http.Get(serverURL + "/api/v1/tasks")
 		if err != nil {
 			dialog.ShowError(err, w)
 			return
@@ -115,7 +119,7 @@ func main() {
 			return
 		}
 		t := tasks[selected]
-		statusLabel.SetText(t.Status)
+		statusLabel.SetText(fmt.Sprintf("%s (%.0f%%)", t.Status, t.Progress))
 		errorKeys = errorKeys[:0]
 		for k := range t.Errors {
 			errorKeys = append(errorKeys, k)
@@ -140,7 +144,7 @@ func main() {
 	}
 
 	createTask := func() {
-		resp, err := http.Post(serverURL+"/tasks", "application/json", nil)
+		resp, err := http.Post(serverURL+"/api/v1/tasks", "application/json", nil)
 		if err != nil {
 			dialog.ShowError(err, w)
 			return
@@ -171,20 +175,16 @@ func main() {
 		if selected < 0 {
 			return
 		}
-		payload := map[string]string{
-			"task_id": tasks[selected].ID,
-			"url":     linkEntry.Text,
-		}
+		payload := map[string]string{"url": linkEntry.Text}
 		body, _ := json.Marshal(payload)
-		resp, err := http.Post(serverURL+"/tasks/links", "application/json", bytes.NewReader(body))
+		resp, err := http.Post(serverURL+"/api/v1/tasks/"+tasks[selected].ID+"/links", "application/json", bytes.NewReader(body))
 		if err != nil {
 			dialog.ShowError(err, w)
 			return
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
-			b, _ := io.ReadAll(resp.Body)
-			dialog.ShowError(fmt.Errorf("add link: %s", string(b)), w)
+			dialog.ShowError(fmt.Errorf("add link: %s", responseError(resp)), w)
 			return
 		}
 		tasks[selected].Urls = append(tasks[selected].Urls, linkEntry.Text)
@@ -196,17 +196,14 @@ func main() {
 		if selected < 0 {
 			return
 		}
-		payload := map[string]string{"task_id": tasks[selected].ID}
-		body, _ := json.Marshal(payload)
-		resp, err := http.Post(serverURL+"/tasks/zip", "application/json", bytes.NewReader(body))
+		resp, err := http.Post(serverURL+"/api/v1/tasks/"+tasks[selected].ID+"/archive", "application/json", nil)
 		if err != nil {
 			dialog.ShowError(err, w)
 			return
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
-			b, _ := io.ReadAll(resp.Body)
-			dialog.ShowError(fmt.Errorf("force zip: %s", string(b)), w)
+			dialog.ShowError(fmt.Errorf("force zip: %s", responseError(resp)), w)
 			return
 		}
 	}
@@ -216,7 +213,7 @@ func main() {
 			return
 		}
 		id := tasks[selected].ID
-		resp, err := http.Get(serverURL + "/tasks/status/" + id)
+		resp, err := http.Get(serverURL + "/api/v1/tasks/" + id)
 		if err != nil {
 			dialog.ShowError(err, w)
 			return
@@ -231,6 +228,7 @@ func main() {
 			Errors     map[string]string `json:"errors"`
 			ArchiveURL string            `json:"archive_url"`
 			Urls       []string          `json:"urls"`
+			Progress   float64           `json:"progress"`
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
 			dialog.ShowError(err, w)
@@ -240,6 +238,7 @@ func main() {
 		tasks[selected].Errors = data.Errors
 		tasks[selected].ArchiveURL = data.ArchiveURL
 		tasks[selected].Urls = data.Urls
+		tasks[selected].Progress = data.Progress
 		updateDetails()
 		taskList.RefreshItem(selected)
 	}
@@ -278,16 +277,38 @@ func main() {
 			}
 			uc.Close()
 		}, w)
-		save.SetFileName(tasks[selected].ID + ".zip")
+		format := tasks[selected].Format
+		if format == "" {
+			format = "zip"
+		}
+		save.SetFileName(tasks[selected].ID + "." + format)
 		save.Show()
 	}
 
+	cancelTask := func() {
+		if selected < 0 {
+			return
+		}
+		id := tasks[selected].ID
+		resp, err := http.Post(serverURL+"/api/v1/tasks/"+id+"/cancel", "application/json", nil)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			dialog.ShowError(fmt.Errorf("cancel task: %s", responseError(resp)), w)
+			return
+		}
+		refreshStatus()
+	}
+
 	deleteTask := func() {
 		if selected < 0 {
 			return
 		}
 		id := tasks[selected].ID
-		req, _ := http.NewRequest(http.MethodDelete, serverURL+"/tasks/delete/"+id, nil)
+		req, _ := http.NewRequest(http.MethodDelete, serverURL+"/api/v1/tasks/"+id, nil)
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			dialog.ShowError(err, w)
@@ -334,6 +355,7 @@ func main() {
 		widget.NewButton("Refresh Status", refreshStatus),
 		widget.NewButton("Force Zip", forceZip),
 		widget.NewButton("Download", downloadTask),
+		widget.NewButton("Cancel Task", cancelTask),
 		widget.NewButton("Delete Task", deleteTask),
 	)
 
@@ -349,4 +371,19 @@ func main() {
 	w.Resize(fyne.NewSize(800, 400))
 	refreshTasks()
 	w.ShowAndRun()
-}
\ No newline at end of file
+}
+
+// responseError достаёт сообщение из JSON-ошибки сервера
+// {"error": {"code": "...", "message": "..."}}
+func responseError(resp *http.Response) string {
+	b, _ := io.ReadAll(resp.Body)
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(b, &e) == nil && e.Error.Message != "" {
+		return e.Error.Message
+	}
+	return string(b)
+}
@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type ArchiveFormat string
+
+const (
+	FormatZip    ArchiveFormat = "zip"
+	FormatTar    ArchiveFormat = "tar"
+	FormatTarGz  ArchiveFormat = "tar.gz"
+	FormatTarZst ArchiveFormat = "tar.zst"
+)
+
+// ParseFormat проверяет название формата; пустая строка означает zip
+func ParseFormat(s string) (ArchiveFormat, error) {
+	switch f := ArchiveFormat(s); f {
+	case "":
+		return FormatZip, nil
+	case FormatZip, FormatTar, FormatTarGz, FormatTarZst:
+		return f, nil
+	}
+	return "", invalidf("unsupported archive format %s", s)
+}
+
+func (f ArchiveFormat) Ext() string {
+	return "." + string(f)
+}
+
+func (f ArchiveFormat) ContentType() string {
+	switch f {
+	case FormatTar:
+		return "application/x-tar"
+	case FormatTarGz:
+		return "application/gzip"
+	case FormatTarZst:
+		return "application/zstd"
+	}
+	return "application/zip"
+}
+
+type Compression string
+
+const (
+	CompressionDeflate Compression = "deflate"
+	CompressionStore   Compression = "store"
+)
+
+// DefaultLevel означает уровень сжатия по умолчанию для выбранного алгоритма
+const DefaultLevel = -1
+
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(s); c {
+	case "":
+		return CompressionDeflate, nil
+	case CompressionDeflate, CompressionStore:
+		return c, nil
+	}
+	return "", invalidf("unsupported compression %s", s)
+}
+
+// ArchiveWriter записывает файлы в архив по одному: запись в предыдущий
+// файл завершается при вызове Create или Close. modified становится
+// временем изменения файла в архиве
+type ArchiveWriter interface {
+	Create(name string, modified time.Time) (io.Writer, error)
+	Close() error
+}
+
+// Время изменения всех файлов в воспроизводимом архиве — начало эпохи DOS
+var deterministicTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newArchiveWriter создаёт писатель архива по опциям задачи. Метод и уровень
+// сжатия применяются к записям zip и к потоку gzip/zstd для tar; файлы с
+// расширениями из storeExts в zip сохраняются без сжатия. Если задан
+// password, записи zip шифруются AES, каждая своим ключом. В режиме
+// Deterministic время изменения файлов фиксируется и лишние метаданные не пишутся
+func newArchiveWriter(w io.Writer, opts TaskOptions, storeExts map[string]struct{}, password string) (ArchiveWriter, error) {
+	level := DefaultLevel
+	if opts.Level != nil {
+		level = *opts.Level
+	}
+	store := opts.Compression == CompressionStore
+	switch opts.Format {
+	case FormatZip:
+		zw := &zipWriter{zw: zip.NewWriter(w), method: zip.Deflate, deterministic: opts.Deterministic}
+		if store {
+			zw.method = zip.Store
+		}
+		if opts.AutoStore != nil && *opts.AutoStore {
+			zw.storeExts = storeExts
+		}
+		if level != DefaultLevel {
+			zw.zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+				return flate.NewWriter(out, level)
+			})
+		}
+		if password != "" {
+			zw.password = password
+			zw.zw.RegisterCompressor(methodWinZipAES, func(out io.Writer) (io.WriteCloser, error) {
+				return newAESCompressor(out, zw.key, zw.keyMethod, level)
+			})
+		}
+		return zw, nil
+	case FormatTar:
+		return &tarWriter{tw: tar.NewWriter(w), deterministic: opts.Deterministic}, nil
+	case FormatTarGz:
+		if store {
+			level = gzip.NoCompression
+		}
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(gw), compressor: gw, deterministic: opts.Deterministic}, nil
+	case FormatTarZst:
+		speed := zstd.SpeedDefault
+		if store {
+			speed = zstd.SpeedFastest
+		} else if level != DefaultLevel {
+			speed = zstd.EncoderLevelFromZstd(level)
+		}
+		zopts := []zstd.EOption{zstd.WithEncoderLevel(speed)}
+		if opts.Deterministic {
+			zopts = append(zopts, zstd.WithEncoderConcurrency(1))
+		}
+		zw, err := zstd.NewWriter(w, zopts...)
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(zw), compressor: zw, deterministic: opts.Deterministic}, nil
+	}
+	return nil, fmt.Errorf("unsupported archive format %s", opts.Format)
+}
+
+type zipWriter struct {
+	zw        *zip.Writer
+	method    uint16
+	storeExts map[string]struct{}
+	password  string // пароль шифрования записей, "" — без шифрования
+	key       aesEntryKey
+	keyMethod uint16
+	// deterministic — писать фиксированное DOS-время без поля extended timestamp
+	deterministic bool
+}
+
+func (z *zipWriter) Create(name string, modified time.Time) (io.Writer, error) {
+	method := z.method
+	if _, ok := z.storeExts[strings.ToLower(filepath.Ext(name))]; ok {
+		method = zip.Store
+	}
+	if z.deterministic {
+		return z.zw.CreateHeader(&zip.FileHeader{
+			Name:         name,
+			Method:       method,
+			ModifiedDate: 1<<5 | 1, // 1980-01-01
+		})
+	}
+	if z.password == "" {
+		return z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modified})
+	}
+	// ключ выводится для каждой записи: их число заранее неизвестно из-за
+	// распаковки вложенных архивов и manifest.json
+	key, err := deriveAESKey(z.password)
+	if err != nil {
+		return nil, err
+	}
+	z.key, z.keyMethod = key, method
+	return z.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   methodWinZipAES,
+		Flags:    0x1,
+		Extra:    aesExtra(method),
+		Modified: modified,
+	})
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+// tarWriter буферизует каждый файл во временный файл, так как заголовок tar
+// должен содержать размер до начала данных
+type tarWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+	name       string
+	modified   time.Time
+	spool      *os.File
+	// deterministic — фиксированное время изменения файлов
+	deterministic bool
+}
+
+func (t *tarWriter) Create(name string, modified time.Time) (io.Writer, error) {
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "linkzipper-*")
+	if err != nil {
+		return nil, err
+	}
+	t.name = name
+	t.modified = modified
+	if t.deterministic {
+		t.modified = deterministicTime
+	}
+	t.spool = f
+	return f, nil
+}
+
+func (t *tarWriter) flush() error {
+	if t.spool == nil {
+		return nil
+	}
+	f := t.spool
+	t.spool = nil
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     t.name,
+		Mode:     0o644,
+		Size:     size,
+		ModTime:  t.modified,
+		Typeflag: tar.TypeReg,
+	}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(t.tw, f)
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	err := t.flush()
+	if cerr := t.tw.Close(); err == nil {
+		err = cerr
+	}
+	if t.compressor != nil {
+		if cerr := t.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,373 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func readArchive(t *testing.T, format ArchiveFormat, data []byte) map[string]string {
+	t.Helper()
+	out := make(map[string]string)
+	if format == FormatZip {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("open zip: %v", err)
+		}
+		for _, f := range zr.File {
+			rc, _ := f.Open()
+			b, _ := io.ReadAll(rc)
+			rc.Close()
+			out[f.Name] = string(b)
+		}
+		return out
+	}
+	var r io.Reader = bytes.NewReader(data)
+	switch format {
+	case FormatTarGz:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("open gzip: %v", err)
+		}
+		r = gr
+	case FormatTarZst:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			t.Fatalf("open zstd: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read tar: %v", err)
+		}
+		b, _ := io.ReadAll(tr)
+		if int64(len(b)) != hdr.Size {
+			t.Fatalf("size mismatch for %s", hdr.Name)
+		}
+		out[hdr.Name] = string(b)
+	}
+	return out
+}
+
+func TestArchiveWriters(t *testing.T) {
+	for _, format := range []ArchiveFormat{FormatZip, FormatTar, FormatTarGz, FormatTarZst} {
+		var buf bytes.Buffer
+		aw, err := newArchiveWriter(&buf, TaskOptions{Format: format}, nil, "")
+		if err != nil {
+			t.Fatalf("%s: new writer: %v", format, err)
+		}
+		w, _ := aw.Create("a.txt", time.Now())
+		w.Write([]byte("first"))
+		w, _ = aw.Create("b.txt", time.Now())
+		w.Write([]byte("second"))
+		if err := aw.Close(); err != nil {
+			t.Fatalf("%s: close: %v", format, err)
+		}
+		files := readArchive(t, format, buf.Bytes())
+		if len(files) != 2 || files["a.txt"] != "first" || files["b.txt"] != "second" {
+			t.Fatalf("%s: unexpected contents %v", format, files)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat(""); err != nil || f != FormatZip {
+		t.Fatalf("expected zip default, got %q %v", f, err)
+	}
+	if f, err := ParseFormat("tar.zst"); err != nil || f != FormatTarZst || f.Ext() != ".tar.zst" {
+		t.Fatalf("unexpected result %q %v", f, err)
+	}
+	if _, err := ParseFormat("rar"); err == nil {
+		t.Fatal("expected error for unsupported format")
+	}
+}
+
+func TestZipCompressionMethods(t *testing.T) {
+	autoStore := true
+	level := 9
+	var buf bytes.Buffer
+	aw, err := newArchiveWriter(&buf, TaskOptions{Format: FormatZip, Level: &level, AutoStore: &autoStore},
+		map[string]struct{}{".jpeg": {}}, "")
+	if err != nil {
+		t.Fatalf("new writer: %v", err)
+	}
+	payload := bytes.Repeat([]byte("a"), 4096)
+	w, _ := aw.Create("doc.txt", time.Now())
+	w.Write(payload)
+	w, _ = aw.Create("photo.JPEG", time.Now())
+	w.Write(payload)
+	aw.Close()
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	if zr.File[0].Method != zip.Deflate || zr.File[0].CompressedSize64 >= uint64(len(payload)) {
+		t.Fatalf("expected deflated text entry, got method %d", zr.File[0].Method)
+	}
+	if zr.File[1].Method != zip.Store {
+		t.Fatalf("expected stored jpeg entry, got method %d", zr.File[1].Method)
+	}
+
+	buf.Reset()
+	aw, _ = newArchiveWriter(&buf, TaskOptions{Format: FormatZip, Compression: CompressionStore}, nil, "")
+	w, _ = aw.Create("doc.txt", time.Now())
+	w.Write(payload)
+	aw.Close()
+	zr, _ = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if zr.File[0].Method != zip.Store {
+		t.Fatalf("expected stored entry, got method %d", zr.File[0].Method)
+	}
+}
+
+func TestDefaultStoreExtensions(t *testing.T) {
+	mgr := NewManager(1, 1, nil)
+	if _, ok := mgr.storeExts[".mp4"]; !ok {
+		t.Fatalf("expected default store extensions, got %v", mgr.storeExts)
+	}
+	mgr.SetStoreExtensions([]string{".JPG"})
+	if _, ok := mgr.storeExts[".jpg"]; !ok || len(mgr.storeExts) != 1 {
+		t.Fatalf("unexpected configured extensions %v", mgr.storeExts)
+	}
+	mgr.SetStoreExtensions(nil)
+	if _, ok := mgr.storeExts[".png"]; !ok {
+		t.Fatalf("expected defaults for empty list, got %v", mgr.storeExts)
+	}
+}
+
+func TestTarCompressionLevels(t *testing.T) {
+	for _, format := range []ArchiveFormat{FormatTarGz, FormatTarZst} {
+		for _, opts := range []TaskOptions{{Compression: CompressionStore}, {Level: new(int)}} {
+			opts.Format = format
+			var buf bytes.Buffer
+			aw, err := newArchiveWriter(&buf, opts, nil, "")
+			if err != nil {
+				t.Fatalf("%s: new writer: %v", format, err)
+			}
+			w, _ := aw.Create("a.txt", time.Now())
+			w.Write([]byte("data"))
+			aw.Close()
+			if files := readArchive(t, format, buf.Bytes()); files["a.txt"] != "data" {
+				t.Fatalf("%s: unexpected contents %v", format, files)
+			}
+		}
+	}
+}
+
+func TestEntryModifiedTime(t *testing.T) {
+	modified := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+	for _, format := range []ArchiveFormat{FormatZip, FormatTar} {
+		var buf bytes.Buffer
+		aw, _ := newArchiveWriter(&buf, TaskOptions{Format: format}, nil, "")
+		w, _ := aw.Create("a.txt", modified)
+		w.Write([]byte("a"))
+		aw.Close()
+
+		var got time.Time
+		if format == FormatZip {
+			zr, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			got = zr.File[0].Modified
+		} else {
+			hdr, _ := tar.NewReader(&buf).Next()
+			got = hdr.ModTime
+		}
+		if !got.Equal(modified) {
+			t.Fatalf("%s: expected %v, got %v", format, modified, got)
+		}
+	}
+}
+
+func TestDeterministicArchives(t *testing.T) {
+	for _, format := range []ArchiveFormat{FormatZip, FormatTar, FormatTarGz, FormatTarZst} {
+		opts := TaskOptions{Format: format, Deterministic: true}.withDefaults(TaskOptions{})
+		build := func(modified time.Time) []byte {
+			var buf bytes.Buffer
+			aw, err := newArchiveWriter(&buf, opts, nil, "")
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			for _, name := range []string{"a.txt", "b.txt"} {
+				w, _ := aw.Create(name, modified)
+				w.Write(bytes.Repeat([]byte(name), 1000))
+			}
+			aw.Close()
+			return buf.Bytes()
+		}
+		first := build(time.Now())
+		second := build(time.Now().Add(time.Hour))
+		if !bytes.Equal(first, second) {
+			t.Fatalf("%s: archives differ", format)
+		}
+		if format == FormatZip {
+			zr, _ := zip.NewReader(bytes.NewReader(first), int64(len(first)))
+			for _, f := range zr.File {
+				if len(f.Extra) != 0 {
+					t.Fatalf("unexpected extra field in %s", f.Name)
+				}
+				if !f.Modified.Equal(time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)) {
+					t.Fatalf("unexpected time %v", f.Modified)
+				}
+			}
+		}
+	}
+}
+
+func TestZip64ManyFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping zip64 test in short mode")
+	}
+	const count = 70000
+	path := filepath.Join(t.TempDir(), "many.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	aw, _ := newArchiveWriter(f, TaskOptions{Format: FormatZip}, nil, "")
+	now := time.Now()
+	for i := 0; i < count; i++ {
+		w, err := aw.Create(fmt.Sprintf("f%05d.txt", i), now)
+		if err != nil {
+			t.Fatalf("create entry: %v", err)
+		}
+		w.Write([]byte("x"))
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	f.Close()
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer zr.Close()
+	if len(zr.File) != count {
+		t.Fatalf("expected %d entries, got %d", count, len(zr.File))
+	}
+	if unzip, err := exec.LookPath("unzip"); err == nil {
+		if out, err := exec.Command(unzip, "-tqq", path).CombinedOutput(); err != nil {
+			t.Fatalf("unzip -t failed: %v\n%s", err, out)
+		}
+	}
+}
+
+// sparseFile хранит только ненулевые участки записанного потока, что
+// позволяет проверить архив больше 4 ГБ без такого же объёма памяти или диска
+type sparseFile struct {
+	segs []sparseSeg
+	size int64
+}
+
+type sparseSeg struct {
+	off  int64
+	n    int64
+	data []byte // nil для участка из нулей
+}
+
+func (s *sparseFile) Write(p []byte) (int, error) {
+	zero := true
+	for _, b := range p {
+		if b != 0 {
+			zero = false
+			break
+		}
+	}
+	if zero {
+		if last := len(s.segs) - 1; last >= 0 && s.segs[last].data == nil {
+			s.segs[last].n += int64(len(p))
+		} else {
+			s.segs = append(s.segs, sparseSeg{off: s.size, n: int64(len(p))})
+		}
+	} else {
+		s.segs = append(s.segs, sparseSeg{off: s.size, n: int64(len(p)), data: append([]byte{}, p...)})
+	}
+	s.size += int64(len(p))
+	return len(p), nil
+}
+
+func (s *sparseFile) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, seg := range s.segs {
+		if n == len(p) {
+			break
+		}
+		pos := off + int64(n)
+		if pos < seg.off || pos >= seg.off+seg.n {
+			continue
+		}
+		end := seg.off + seg.n
+		c := int(min(int64(len(p)-n), end-pos))
+		if seg.data == nil {
+			clear(p[n : n+c])
+		} else {
+			copy(p[n:n+c], seg.data[pos-seg.off:])
+		}
+		n += c
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestZip64LargeEntry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping zip64 test in short mode")
+	}
+	const size = 1<<32 + 1<<20
+	var sf sparseFile
+	aw, _ := newArchiveWriter(&sf, TaskOptions{Format: FormatZip, Compression: CompressionStore}, nil, "")
+	w, _ := aw.Create("big.bin", time.Now())
+	if _, err := io.CopyN(w, zeroReader{}, size); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	w, _ = aw.Create("small.txt", time.Now())
+	w.Write([]byte("after"))
+	if err := aw.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	zr, err := zip.NewReader(&sf, sf.size)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	if len(zr.File) != 2 || zr.File[0].UncompressedSize64 != size {
+		t.Fatalf("unexpected entries: %d, size %d", len(zr.File), zr.File[0].UncompressedSize64)
+	}
+	rc, _ := zr.File[0].Open()
+	n, err := io.Copy(io.Discard, rc)
+	rc.Close()
+	if err != nil || n != size {
+		t.Fatalf("read big entry: %d %v", n, err)
+	}
+	rc, _ = zr.File[1].Open()
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "after" {
+		t.Fatalf("unexpected entry after zip64 entry: %q", data)
+	}
+}
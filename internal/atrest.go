@@ -0,0 +1,351 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Шифрование архивов на диске: поток делится на блоки по atRestChunk байт,
+// каждый блок шифруется AES-GCM. Nonce блока — случайный префикс файла,
+// номер блока и флаг последнего блока, что защищает от перестановки и
+// усечения. Заголовок файла содержит идентификатор ключа для ротации.
+const (
+	atRestMagic     = "LZE1"
+	atRestExt       = ".enc"
+	atRestChunk     = 64 * 1024
+	atRestPrefixLen = 7
+	atRestTagLen    = 16
+)
+
+// KeyRing хранит ключи шифрования архивов; новые архивы шифруются активным ключом
+type KeyRing struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// LoadKeyRing читает ключи из конфига. Ключ задаётся в base64 прямо в конфиге
+// или в файле; без activeKey шифрование на диске выключено и возвращается nil
+func LoadKeyRing(cfg StorageConfig) (*KeyRing, error) {
+	if cfg.ActiveKey == "" {
+		return nil, nil
+	}
+	kr := &KeyRing{active: cfg.ActiveKey, keys: make(map[string]cipher.AEAD)}
+	for _, k := range cfg.Keys {
+		encoded := k.Key
+		if k.File != "" {
+			data, err := os.ReadFile(k.File)
+			if err != nil {
+				return nil, fmt.Errorf("read key %s: %v", k.ID, err)
+			}
+			encoded = string(data)
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("decode key %s: %v", k.ID, err)
+		}
+		if err := kr.Add(k.ID, raw); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := kr.keys[kr.active]; !ok {
+		return nil, fmt.Errorf("active key %s not found", kr.active)
+	}
+	return kr, nil
+}
+
+// NewKeyRing создаёт пустой набор ключей с указанным активным ключом
+func NewKeyRing(active string) *KeyRing {
+	return &KeyRing{active: active, keys: make(map[string]cipher.AEAD)}
+}
+
+// Add добавляет 256-битный ключ с идентификатором id
+func (kr *KeyRing) Add(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return errors.New("invalid key id")
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("key %s must be 32 bytes", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	kr.keys[id] = aead
+	return nil
+}
+
+func atRestNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[atRestPrefixLen:], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// atRestWriter шифрует поток активным ключом
+type atRestWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	buf    []byte
+	index  uint32
+}
+
+// NewEncryptWriter начинает зашифрованный файл в w; Close дописывает последний блок
+func (kr *KeyRing) NewEncryptWriter(w io.Writer) (io.WriteCloser, error) {
+	prefix := make([]byte, atRestPrefixLen)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	header := append([]byte(atRestMagic), byte(len(kr.active)))
+	header = append(header, kr.active...)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &atRestWriter{
+		w:      w,
+		aead:   kr.keys[kr.active],
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, atRestChunk),
+	}, nil
+}
+
+func (a *atRestWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if len(a.buf) == atRestChunk {
+			if err := a.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(a.buf[len(a.buf):atRestChunk], p)
+		a.buf = a.buf[:len(a.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (a *atRestWriter) flush(last bool) error {
+	out := a.aead.Seal(nil, atRestNonce(a.prefix, a.index, last), a.buf, a.header)
+	a.index++
+	a.buf = a.buf[:0]
+	_, err := a.w.Write(out)
+	return err
+}
+
+func (a *atRestWriter) Close() error {
+	return a.flush(true)
+}
+
+// readAtRestHeader читает заголовок; ok=false, если заголовка нет
+func readAtRestHeader(r io.Reader) (header []byte, keyID string, ok bool, err error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(atRestMagic) + 1)
+	if err != nil || string(magic[:len(atRestMagic)]) != atRestMagic {
+		return nil, "", false, nil
+	}
+	n := len(atRestMagic) + 1 + int(magic[len(atRestMagic)]) + atRestPrefixLen
+	header = make([]byte, n)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, "", false, err
+	}
+	return header, string(header[len(atRestMagic)+1 : n-atRestPrefixLen]), true, nil
+}
+
+// atRestReader расшифровывает файл блоками и поддерживает Seek,
+// поэтому подходит для http.ServeContent с Range-запросами
+type atRestReader struct {
+	f      *os.File
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	chunks int64
+	size   int64
+	pos    int64
+	cached int64
+	plain  []byte
+}
+
+func (r *atRestReader) chunkAt(i int64) ([]byte, error) {
+	if i == r.cached {
+		return r.plain, nil
+	}
+	ctChunk := int64(atRestChunk + atRestTagLen)
+	buf := make([]byte, ctChunk)
+	n, err := r.f.ReadAt(buf, int64(len(r.header))+i*ctChunk)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	plain, err := r.aead.Open(nil, atRestNonce(r.prefix, uint32(i), i == r.chunks-1), buf[:n], r.header)
+	if err != nil {
+		return nil, errors.New("archive decryption failed")
+	}
+	r.cached, r.plain = i, plain
+	return plain, nil
+}
+
+func (r *atRestReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	plain, err := r.chunkAt(r.pos / atRestChunk)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, plain[r.pos%atRestChunk:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *atRestReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *atRestReader) Close() error {
+	return r.f.Close()
+}
+
+// OpenArchive открывает архив для чтения. Файлы с расширением .enc
+// зашифрованы на диске и расшифровываются на лету ключом из kr
+func OpenArchive(path string, kr *KeyRing) (io.ReadSeekCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, atRestExt) {
+		return f, nil
+	}
+	header, keyID, ok, err := readAtRestHeader(f)
+	if err == nil && !ok {
+		err = errors.New("invalid encrypted archive header")
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var aead cipher.AEAD
+	if kr != nil {
+		aead = kr.keys[keyID]
+	}
+	if aead == nil {
+		f.Close()
+		return nil, fmt.Errorf("unknown archive key %s", keyID)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	ctChunk := int64(atRestChunk + atRestTagLen)
+	ctLen := st.Size() - int64(len(header))
+	chunks := (ctLen + ctChunk - 1) / ctChunk
+	if chunks == 0 {
+		f.Close()
+		return nil, errors.New("truncated archive")
+	}
+	size := ctLen - chunks*atRestTagLen
+	return &atRestReader{
+		f:      f,
+		aead:   aead,
+		header: header,
+		prefix: header[len(header)-atRestPrefixLen:],
+		chunks: chunks,
+		size:   size,
+		cached: -1,
+	}, nil
+}
+
+// Rekey перешифровывает файл активным ключом, если он зашифрован другим.
+// Файл заменяется атомарно через переименование
+func (kr *KeyRing) Rekey(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	_, keyID, ok, err := readAtRestHeader(f)
+	f.Close()
+	if err == nil && !ok {
+		err = errors.New("invalid encrypted archive header")
+	}
+	if err != nil || keyID == kr.active {
+		return false, err
+	}
+	src, err := OpenArchive(path, kr)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".rekey-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	enc, err := kr.NewEncryptWriter(tmp)
+	if err == nil {
+		_, err = io.Copy(enc, src)
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), path)
+}
+
+// RekeyDir перешифровывает активным ключом все архивы задач в каталоге
+func (kr *KeyRing) RekeyDir(dir string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "task-*"+atRestExt))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, p := range paths {
+		changed, err := kr.Rekey(p)
+		if err != nil {
+			return count, fmt.Errorf("%s: %v", p, err)
+		}
+		if changed {
+			count++
+			Logger.WithField("file", p).Info("archive re-encrypted")
+		}
+	}
+	return count, nil
+}
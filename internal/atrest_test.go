@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeyRing(t *testing.T, ids ...string) *KeyRing {
+	t.Helper()
+	kr := NewKeyRing(ids[0])
+	for _, id := range ids {
+		key := make([]byte, 32)
+		rand.Read(key)
+		if err := kr.Add(id, key); err != nil {
+			t.Fatalf("add key: %v", err)
+		}
+	}
+	return kr
+}
+
+func writeEncrypted(t *testing.T, kr *KeyRing, path string, data []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer f.Close()
+	enc, err := kr.NewEncryptWriter(f)
+	if err != nil {
+		t.Fatalf("encrypt writer: %v", err)
+	}
+	enc.Write(data)
+	if err := enc.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+func TestAtRestRoundTrip(t *testing.T) {
+	kr := testKeyRing(t, "k1")
+	dir := t.TempDir()
+	for _, size := range []int{0, 1, atRestChunk, atRestChunk + 1, 3*atRestChunk - 5} {
+		data := make([]byte, size)
+		rand.Read(data)
+		path := filepath.Join(dir, "task-1.zip"+atRestExt)
+		writeEncrypted(t, kr, path, data)
+
+		raw, _ := os.ReadFile(path)
+		if size > 16 && bytes.Contains(raw, data) {
+			t.Fatalf("size %d: plaintext found on disk", size)
+		}
+		r, err := OpenArchive(path, kr)
+		if err != nil {
+			t.Fatalf("size %d: open: %v", size, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("size %d: roundtrip mismatch: %v", size, err)
+		}
+		if size > 10 {
+			r.Seek(int64(size-10), io.SeekStart)
+			tail, _ := io.ReadAll(r)
+			if !bytes.Equal(tail, data[size-10:]) {
+				t.Fatalf("size %d: seek mismatch", size)
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestAtRestTamperAndTruncate(t *testing.T) {
+	kr := testKeyRing(t, "k1")
+	path := filepath.Join(t.TempDir(), "task-1.zip"+atRestExt)
+	data := bytes.Repeat([]byte("x"), 2*atRestChunk+100)
+	writeEncrypted(t, kr, path, data)
+	raw, _ := os.ReadFile(path)
+
+	tampered := append([]byte{}, raw...)
+	tampered[len(tampered)-1] ^= 1
+	os.WriteFile(path, tampered, 0o644)
+	r, _ := OpenArchive(path, kr)
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("expected error for tampered archive")
+	}
+	r.Close()
+
+	// отбрасываем последний блок целиком: предпоследний не помечен как последний
+	os.WriteFile(path, raw[:len(raw)-(100+atRestTagLen)], 0o644)
+	r, _ = OpenArchive(path, kr)
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("expected error for truncated archive")
+	}
+	r.Close()
+
+	os.WriteFile(path, raw, 0o644)
+	if _, err := OpenArchive(path, testKeyRing(t, "other")); err == nil {
+		t.Fatal("expected error for unknown key")
+	}
+}
+
+func TestRekeyDir(t *testing.T) {
+	old := testKeyRing(t, "k1")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "task-7.zip"+atRestExt)
+	writeEncrypted(t, old, path, []byte("archive"))
+	os.WriteFile(filepath.Join(dir, "task-8.zip"), []byte("plain"), 0o644)
+
+	kr := testKeyRing(t, "k2")
+	kr.keys["k1"] = old.keys["k1"]
+	count, err := kr.RekeyDir(dir)
+	if err != nil || count != 1 {
+		t.Fatalf("rekey: %d %v", count, err)
+	}
+	if count, _ := kr.RekeyDir(dir); count != 0 {
+		t.Fatalf("expected nothing to rekey, got %d", count)
+	}
+	if _, err := OpenArchive(path, old); err == nil {
+		t.Fatal("archive still readable with old key only")
+	}
+	r, err := OpenArchive(path, testKeyRingFrom(kr, "k2"))
+	if err != nil {
+		t.Fatalf("open with new key: %v", err)
+	}
+	defer r.Close()
+	if data, _ := io.ReadAll(r); string(data) != "archive" {
+		t.Fatalf("unexpected contents %q", data)
+	}
+}
+
+func testKeyRingFrom(kr *KeyRing, id string) *KeyRing {
+	out := NewKeyRing(id)
+	out.keys[id] = kr.keys[id]
+	return out
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	if kr, err := LoadKeyRing(StorageConfig{}); kr != nil || err != nil {
+		t.Fatalf("expected disabled keyring, got %v %v", kr, err)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	keyFile := filepath.Join(t.TempDir(), "k2.key")
+	os.WriteFile(keyFile, []byte(encoded+"\n"), 0o600)
+
+	kr, err := LoadKeyRing(StorageConfig{
+		ActiveKey: "k2",
+		Keys:      []KeyConfig{{ID: "k1", Key: encoded}, {ID: "k2", File: keyFile}},
+	})
+	if err != nil {
+		t.Fatalf("load keyring: %v", err)
+	}
+	if len(kr.keys) != 2 || kr.active != "k2" {
+		t.Fatalf("unexpected keyring: %+v", kr)
+	}
+	if _, err := LoadKeyRing(StorageConfig{ActiveKey: "k3", Keys: []KeyConfig{{ID: "k1", Key: encoded}}}); err == nil {
+		t.Fatal("expected error for missing active key")
+	}
+	if _, err := LoadKeyRing(StorageConfig{ActiveKey: "k1", Keys: []KeyConfig{{ID: "k1", Key: "c2hvcnQ="}}}); err == nil {
+		t.Fatal("expected error for short key")
+	}
+}
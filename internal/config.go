@@ -6,9 +6,11 @@ import (
 )
 
 type ServerConfig struct {
-	Port int `mapstructure:"port"`
+	Port int    `mapstructure:"port"`
 	Key  string `mapstructure:"key"`
 	Crt  string `mapstructure:"crt"`
+	// ValidateRequests включает проверку запросов по описанию OpenAPI
+	ValidateRequests bool `mapstructure:"validateRequests"`
 }
 
 type LimitsConfig struct {
@@ -22,10 +24,68 @@ type LoggingConfig struct {
 	File  string `mapstructure:"file"`
 }
 
+type ArchiveConfig struct {
+	Format          string        `mapstructure:"format"`
+	Compression     string        `mapstructure:"compression"`
+	Level           *int          `mapstructure:"level"`
+	AutoStore       bool          `mapstructure:"autoStore"`
+	StoreExtensions []string      `mapstructure:"storeExtensions"`
+	KeepVersions    int           `mapstructure:"keepVersions"`
+	Extract         ExtractConfig `mapstructure:"extract"`
+}
+
+type ExtractConfig struct {
+	MaxRatio     int64 `mapstructure:"maxRatio"`
+	MaxTotalSize int64 `mapstructure:"maxTotalSize"`
+	MaxEntries   int   `mapstructure:"maxEntries"`
+}
+
+type KeyConfig struct {
+	ID   string `mapstructure:"id"`
+	Key  string `mapstructure:"key"`
+	File string `mapstructure:"file"`
+}
+
+type StorageConfig struct {
+	ActiveKey string      `mapstructure:"activeKey"`
+	Keys      []KeyConfig `mapstructure:"keys"`
+}
+
+type ScanConfig struct {
+	Clamd         string `mapstructure:"clamd"`
+	Policy        string `mapstructure:"policy"`
+	QuarantineDir string `mapstructure:"quarantineDir"`
+	Timeout       int    `mapstructure:"timeout"` // секунды
+}
+
+type SigningConfig struct {
+	Key  string `mapstructure:"key"`
+	File string `mapstructure:"file"`
+}
+
+type WebhookSubscriptionConfig struct {
+	URL    string   `mapstructure:"url"`
+	Events []string `mapstructure:"events"` // пусто — все события
+}
+
+type WebhooksConfig struct {
+	Secret        string                      `mapstructure:"secret"`
+	SecretFile    string                      `mapstructure:"secretFile"`
+	QueueDir      string                      `mapstructure:"queueDir"`
+	MaxAttempts   int                         `mapstructure:"maxAttempts"`
+	Timeout       int                         `mapstructure:"timeout"` // секунды
+	Subscriptions []WebhookSubscriptionConfig `mapstructure:"subscriptions"`
+}
+
 type Config struct {
-	Server  ServerConfig  `mapstructure:"server"`
-	Limits  LimitsConfig  `mapstructure:"limits"`
-	Logging LoggingConfig `mapstructure:"logging"`
+	Server   ServerConfig   `mapstructure:"server"`
+	Limits   LimitsConfig   `mapstructure:"limits"`
+	Archive  ArchiveConfig  `mapstructure:"archive"`
+	Storage  StorageConfig  `mapstructure:"storage"`
+	Signing  SigningConfig  `mapstructure:"signing"`
+	Scan     ScanConfig     `mapstructure:"scan"`
+	Webhooks WebhooksConfig `mapstructure:"webhooks"`
+	Logging  LoggingConfig  `mapstructure:"logging"`
 }
 
 func Load() *Config {
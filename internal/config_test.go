@@ -10,7 +10,7 @@ import (
 
 func TestLoad(t *testing.T) {
 	tmpDir := t.TempDir()
-	cfgContent := []byte("server:\n  port: 9090\n  key: server.key\n  crt: server.crt\nlimits:\n  maxTasks: 5\n  maxFilesPerTask: 3\n  allowedExtensions:\n    - \".txt\"\nlogging:\n  level: debug\n  file: app.log\n")
+	cfgContent := []byte("server:\n  port: 9090\n  key: server.key\n  crt: server.crt\nlimits:\n  maxTasks: 5\n  maxFilesPerTask: 3\n  allowedExtensions:\n    - \".txt\"\narchive:\n  format: tar.gz\n  compression: store\n  level: 5\n  autoStore: true\n  storeExtensions:\n    - \".png\"\nlogging:\n  level: debug\n  file: app.log\n")
 	if err := os.WriteFile(filepath.Join(tmpDir, "config.yaml"), cfgContent, 0644); err != nil {
 		t.Fatalf("write config: %v", err)
 	}
@@ -38,6 +38,15 @@ func TestLoad(t *testing.T) {
 	if cfg.Logging.Level != "debug" || cfg.Logging.File != "app.log" {
 		t.Fatalf("unexpected logging config: %+v", cfg.Logging)
 	}
+	if cfg.Archive.Format != "tar.gz" || cfg.Archive.Compression != "store" || !cfg.Archive.AutoStore {
+		t.Fatalf("unexpected archive config: %+v", cfg.Archive)
+	}
+	if cfg.Archive.Level == nil || *cfg.Archive.Level != 5 {
+		t.Fatalf("unexpected compression level: %v", cfg.Archive.Level)
+	}
+	if len(cfg.Archive.StoreExtensions) != 1 || cfg.Archive.StoreExtensions[0] != ".png" {
+		t.Fatalf("unexpected store extensions: %+v", cfg.Archive.StoreExtensions)
+	}
 	if len(cfg.Limits.AllowedExts) != 1 || cfg.Limits.AllowedExts[0] != ".txt" {
 		t.Fatalf("unexpected allowed extensions: %+v", cfg.Limits.AllowedExts)
 	}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Dedup задаёт, что делать с файлом, содержимое которого совпадает с уже
+// добавленным в архив: пропустить его или записать псевдоним в manifest.json
+type Dedup string
+
+const (
+	DedupSkip  Dedup = "skip"
+	DedupAlias Dedup = "alias"
+)
+
+func ParseDedup(s string) (Dedup, error) {
+	switch d := Dedup(s); d {
+	case "", DedupSkip, DedupAlias:
+		return d, nil
+	}
+	return "", invalidf("unsupported dedup mode %s", s)
+}
+
+// errDuplicate возвращается, если содержимое файла уже есть в архиве
+type errDuplicate struct {
+	entry string
+}
+
+func (e *errDuplicate) Error() string {
+	return "duplicate of " + e.entry
+}
+
+// contentIndex собирает SHA-256 файлов, уже попавших в архив: при
+// пересборке версии в него входят и файлы предыдущей версии
+func contentIndex(task *Task) map[string]string {
+	seen := make(map[string]string)
+	for i, entry := range task.entries {
+		if entry != "" && task.Files[i].SHA256 != "" {
+			seen[task.Files[i].SHA256] = entry
+		}
+	}
+	return seen
+}
+
+// duplicates сопоставляет ссылки, не попавшие в архив из-за совпадения
+// содержимого, с файлами архива
+func duplicates(files []FileProgress) map[string]string {
+	dups := make(map[string]string)
+	for _, f := range files {
+		if f.DuplicateOf != "" {
+			dups[f.URL] = f.DuplicateOf
+		}
+	}
+	return dups
+}
+
+// writeAliases дописывает в архив manifest.json со ссылками, содержимое
+// которых совпало с уже добавленными файлами
+func (m *TaskManager) writeAliases(task *Task, aw ArchiveWriter) error {
+	type alias struct {
+		URL   string `json:"url"`
+		Entry string `json:"entry"`
+	}
+	var aliases []alias
+	m.mu.Lock()
+	for _, fp := range task.Files {
+		if fp.DuplicateOf != "" {
+			aliases = append(aliases, alias{URL: fp.URL, Entry: fp.DuplicateOf})
+		}
+	}
+	m.mu.Unlock()
+	if len(aliases) == 0 {
+		return nil
+	}
+	w, err := aw.Create(manifestName, time.Now())
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{"aliases": aliases})
+}
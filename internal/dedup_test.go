@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sameContentServer отдаёт одинаковое содержимое для a.txt, b.txt и d.txt
+func sameContentServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/c.txt" {
+			w.Write([]byte("other"))
+			return
+		}
+		w.Write([]byte("same"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDedupSkip(t *testing.T) {
+	srv := sameContentServer(t)
+	mgr := NewManager(2, 3, []string{".txt"})
+	id, _ := mgr.CreateWithOptions(TaskOptions{Dedup: DedupSkip})
+	for _, name := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		mgr.AddURL(id, srv.URL+name)
+	}
+	task := waitVersion(t, mgr, id, 1)
+	defer mgr.Delete(id)
+
+	files := readVersion(t, mgr, task, FormatZip, 1)
+	if len(files) != 2 || files["a.txt"] != "same" || files["c.txt"] != "other" {
+		t.Fatalf("unexpected archive contents %v", files)
+	}
+	progress, _ := mgr.Progress(task)
+	if progress[1].DuplicateOf != "a.txt" || progress[1].State != FileDone || progress[0].SHA256 != progress[1].SHA256 {
+		t.Fatalf("expected b.txt deduplicated against a.txt, got %+v", progress[1])
+	}
+	if dups := duplicates(progress); len(dups) != 1 || dups[srv.URL+"/b.txt"] != "a.txt" {
+		t.Fatalf("unexpected duplicates %v", dups)
+	}
+
+	// новая версия сверяется и с файлами, скопированными из предыдущей
+	if err := mgr.AddURL(id, srv.URL+"/d.txt"); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	mgr.ForceZip(id)
+	task = waitVersion(t, mgr, id, 2)
+	if files := readVersion(t, mgr, task, FormatZip, 2); len(files) != 2 {
+		t.Fatalf("unexpected version 2 contents %v", files)
+	}
+	progress, _ = mgr.Progress(task)
+	if progress[3].DuplicateOf != "a.txt" {
+		t.Fatalf("expected d.txt deduplicated against a.txt, got %+v", progress[3])
+	}
+}
+
+func TestDedupAlias(t *testing.T) {
+	srv := sameContentServer(t)
+	mgr := NewManager(2, 2, []string{".txt"})
+	id, _ := mgr.CreateWithOptions(TaskOptions{Format: FormatTarGz, Dedup: DedupAlias})
+	mgr.AddURL(id, srv.URL+"/a.txt")
+	mgr.AddURL(id, srv.URL+"/b.txt")
+	task := waitVersion(t, mgr, id, 1)
+	defer mgr.Delete(id)
+
+	files := readVersion(t, mgr, task, FormatTarGz, 1)
+	if len(files) != 2 || files["a.txt"] != "same" {
+		t.Fatalf("unexpected archive contents %v", files)
+	}
+	var manifest struct {
+		Aliases []struct {
+			URL   string `json:"url"`
+			Entry string `json:"entry"`
+		} `json:"aliases"`
+	}
+	if err := json.NewDecoder(strings.NewReader(files[manifestName])).Decode(&manifest); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if len(manifest.Aliases) != 1 || manifest.Aliases[0].URL != srv.URL+"/b.txt" || manifest.Aliases[0].Entry != "a.txt" {
+		t.Fatalf("unexpected aliases %+v", manifest.Aliases)
+	}
+}
+
+func TestDedupValidate(t *testing.T) {
+	if err := (TaskOptions{Dedup: "hardlink"}).Validate(); err == nil {
+		t.Fatal("expected error for unknown dedup mode")
+	}
+}
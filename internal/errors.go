@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// Ошибки менеджера задач. Обработчики HTTP сравнивают их через errors.Is
+// и выбирают по ним код ответа
+var (
+	ErrTaskNotFound     = errors.New("task not found")
+	ErrJobNotFound      = errors.New("job not found")
+	ErrVersionNotFound  = errors.New("version not found")
+	ErrPartNotFound     = errors.New("part not found")
+	ErrLinkNotFound     = errors.New("link not found")
+	ErrSignatureMissing = errors.New("signature not available")
+	ErrSigningDisabled  = errors.New("signing is not configured")
+	ErrTaskCompleted    = errors.New("task already completed")
+	ErrTaskProcessing   = errors.New("task already processing")
+	ErrTaskEncrypted    = errors.New("encrypted task cannot be reopened")
+	ErrNotReady         = errors.New("archive not ready")
+	ErrArchiveSplit     = errors.New("archive is split into parts")
+	ErrLinkArchived     = errors.New("link is already in an archive version")
+	ErrMaxFiles         = errors.New("max files per task reached")
+	ErrNoFiles          = errors.New("no files to archive")
+	ErrInvalidLinks     = errors.New("invalid links")
+	ErrServerBusy       = errors.New("server busy: max tasks reached")
+	errUnsupportedMedia = errors.New("request body must be application/json")
+	errTrailingJSON     = errors.New("unexpected data after JSON body")
+)
+
+// ValidationError — ошибка во входных данных: опциях задачи, ссылке или параметре запроса
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// errorCodes сопоставляет ошибки с кодом ответа и машинным кодом ошибки
+var errorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrTaskNotFound, http.StatusNotFound, "task_not_found"},
+	{ErrJobNotFound, http.StatusNotFound, "job_not_found"},
+	{ErrVersionNotFound, http.StatusNotFound, "version_not_found"},
+	{ErrPartNotFound, http.StatusNotFound, "part_not_found"},
+	{ErrLinkNotFound, http.StatusNotFound, "link_not_found"},
+	{ErrSignatureMissing, http.StatusNotFound, "signature_not_found"},
+	{ErrSigningDisabled, http.StatusNotFound, "signing_disabled"},
+	{ErrTaskCompleted, http.StatusConflict, "task_completed"},
+	{ErrTaskProcessing, http.StatusConflict, "task_processing"},
+	{ErrTaskEncrypted, http.StatusConflict, "task_encrypted"},
+	{ErrNotReady, http.StatusConflict, "not_ready"},
+	{ErrArchiveSplit, http.StatusConflict, "archive_split"},
+	{ErrLinkArchived, http.StatusConflict, "link_archived"},
+	{ErrMaxFiles, http.StatusUnprocessableEntity, "max_files"},
+	{ErrNoFiles, http.StatusUnprocessableEntity, "no_files"},
+	{ErrInvalidLinks, http.StatusUnprocessableEntity, "invalid_links"},
+	{ErrServerBusy, http.StatusTooManyRequests, "server_busy"},
+	{errUnsupportedMedia, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	{errTrailingJSON, http.StatusBadRequest, "invalid_json"},
+}
+
+// errorStatus возвращает код ответа и машинный код для ошибки
+func errorStatus(err error) (int, string) {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.status, c.code
+		}
+	}
+	var verr *ValidationError
+	var tooLarge *http.MaxBytesError
+	var syntax *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &verr):
+		return http.StatusUnprocessableEntity, "validation_failed"
+	case errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge, "body_too_large"
+	case errors.As(err, &syntax), errors.As(err, &typeErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest, "invalid_json"
+	}
+	return http.StatusInternalServerError, "internal_error"
+}
+
+// writeError отвечает ошибкой в едином формате
+// {"error": {"code": "...", "message": "...", "details": ...}}
+func writeError(w http.ResponseWriter, err error, details interface{}) {
+	status, code := errorStatus(err)
+	body := map[string]interface{}{"code": code, "message": err.Error()}
+	if details != nil {
+		body["details"] = details
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
+
+// writeJSON отвечает значением v в формате JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// linkDetails оформляет ошибки проверки ссылок как details ответа
+func linkDetails(errs []LinkError) interface{} {
+	if errs == nil {
+		return nil
+	}
+	return map[string]interface{}{"links": errs}
+}
+
+// maxBodySize ограничивает размер JSON-тела запроса
+const maxBodySize = 1 << 20
+
+// decodeJSON читает JSON-тело запроса в v. Пустое тело допускается и
+// оставляет v без изменений; тело другого типа, больше maxBodySize или с
+// данными после JSON-значения отклоняется
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != "application/json" {
+			return errUnsupportedMedia
+		}
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		return errTrailingJSON
+	}
+	return nil
+}
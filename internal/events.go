@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// Типы событий потока задач
+const (
+	TaskEventTask    = "task"    // изменилось состояние или список ссылок задачи
+	TaskEventFile    = "file"    // изменился прогресс файла
+	TaskEventDeleted = "deleted" // задача удалена
+)
+
+// eventHistory — сколько последних событий хранится для возобновления по
+// Last-Event-ID; subscriberBuffer — очередь одного подписчика
+const (
+	eventHistory     = 1024
+	subscriberBuffer = 256
+)
+
+// fileEventInterval ограничивает частоту событий о прогрессе скачивания файла
+var fileEventInterval = 250 * time.Millisecond
+
+// TaskEvent — событие потока задач
+type TaskEvent struct {
+	ID       uint64        `json:"-"`
+	Type     string        `json:"type"`
+	TaskID   string        `json:"task_id"`
+	Status   TaskStatus    `json:"status,omitempty"`
+	Progress float64       `json:"progress"`
+	Links    int           `json:"links,omitempty"`
+	Version  int           `json:"version,omitempty"`
+	Index    *int          `json:"index,omitempty"`
+	File     *FileProgress `json:"file,omitempty"`
+}
+
+// Subscription — подписка на события одной задачи или всех задач. Канал
+// закрывается, если подписчик не успевает читать события: клиент должен
+// переподключиться с Last-Event-ID
+type Subscription struct {
+	C      <-chan TaskEvent
+	ch     chan TaskEvent
+	taskID string
+}
+
+// eventBroker раздаёт события подписчикам, не блокируя издателя
+type eventBroker struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []TaskEvent
+	subs    map[*Subscription]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: make(map[*Subscription]struct{})}
+}
+
+func (b *eventBroker) publish(ev TaskEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	ev.ID = b.seq
+	if len(b.history) == eventHistory {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:eventHistory-1]
+	}
+	b.history = append(b.history, ev)
+	for s := range b.subs {
+		if s.taskID != "" && s.taskID != ev.TaskID {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			delete(b.subs, s)
+			close(s.ch)
+			Logger.WithField("task_id", s.taskID).Warn("slow event subscriber dropped")
+		}
+	}
+}
+
+// subscribe регистрирует подписчика и возвращает пропущенные события после
+// lastID. ok == false, если часть из них уже вытеснена из истории
+func (b *eventBroker) subscribe(taskID string, lastID uint64) (s *Subscription, missed []TaskEvent, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan TaskEvent, subscriberBuffer)
+	s = &Subscription{C: ch, ch: ch, taskID: taskID}
+	b.subs[s] = struct{}{}
+	if lastID == 0 || lastID > b.seq {
+		return s, nil, false
+	}
+	if len(b.history) > 0 && b.history[0].ID > lastID+1 {
+		return s, nil, false
+	}
+	for _, ev := range b.history {
+		if ev.ID > lastID && (taskID == "" || ev.TaskID == taskID) {
+			missed = append(missed, ev)
+		}
+	}
+	return s, missed, true
+}
+
+func (b *eventBroker) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Subscribe подписывает на события задачи taskID ("" — всех задач). Если
+// события после lastID сохранились, они возвращаются для повторной отправки;
+// иначе возвращается текущее состояние задач как события с последним ID
+func (m *TaskManager) Subscribe(taskID string, lastID uint64) (*Subscription, []TaskEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var tasks []*Task
+	if taskID != "" {
+		task, ok := m.tasks[taskID]
+		if !ok {
+			task, ok = m.completed[taskID]
+		}
+		if !ok {
+			return nil, nil, ErrTaskNotFound
+		}
+		tasks = []*Task{task}
+	} else {
+		for _, t := range m.tasks {
+			tasks = append(tasks, t)
+		}
+		for _, t := range m.completed {
+			tasks = append(tasks, t)
+		}
+	}
+	// события публикуются под m.mu, поэтому снимок согласован с номером seq
+	s, missed, ok := m.events.subscribe(taskID, lastID)
+	if ok {
+		return s, missed, nil
+	}
+	m.events.mu.Lock()
+	seq := m.events.seq
+	m.events.mu.Unlock()
+	snapshot := make([]TaskEvent, 0, len(tasks))
+	for _, t := range tasks {
+		ev := m.taskEvent(t)
+		ev.ID = seq
+		snapshot = append(snapshot, ev)
+	}
+	return s, snapshot, nil
+}
+
+// Unsubscribe отменяет подписку
+func (m *TaskManager) Unsubscribe(s *Subscription) {
+	m.events.unsubscribe(s)
+}
+
+// taskEvent собирает событие о состоянии задачи. Вызывается под m.mu
+func (m *TaskManager) taskEvent(task *Task) TaskEvent {
+	return TaskEvent{
+		Type:     TaskEventTask,
+		TaskID:   task.ID,
+		Status:   task.Status,
+		Progress: taskProgress(task),
+		Links:    len(task.Urls),
+		Version:  task.Version,
+	}
+}
+
+// publishTask сообщает подписчикам о состоянии задачи. Вызывается под m.mu
+func (m *TaskManager) publishTask(task *Task) {
+	m.events.publish(m.taskEvent(task))
+}
+
+// publishFile сообщает подписчикам о прогрессе файла. Вызывается под m.mu.
+// Временные задачи вне m.tasks (потоковый /zip, удалённые) не публикуются
+func (m *TaskManager) publishFile(task *Task, fp *FileProgress) {
+	if m.tasks[task.ID] != task {
+		return
+	}
+	for i, f := range task.Files {
+		if f != fp {
+			continue
+		}
+		fp.publishedAt = time.Now()
+		file := *fp
+		index := i
+		m.events.publish(TaskEvent{
+			Type:     TaskEventFile,
+			TaskID:   task.ID,
+			Status:   task.Status,
+			Progress: taskProgress(task),
+			Index:    &index,
+			File:     &file,
+		})
+		return
+	}
+}
+
+// publishDeleted сообщает подписчикам об удалении задачи. Вызывается под m.mu
+func (m *TaskManager) publishDeleted(id string) {
+	m.events.publish(TaskEvent{Type: TaskEventDeleted, TaskID: id})
+}
@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBrokerDropsSlowSubscriber(t *testing.T) {
+	b := newEventBroker()
+	slow, _, _ := b.subscribe("", 0)
+	other, _, _ := b.subscribe("task-2", 0)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i <= subscriberBuffer; i++ {
+			b.publish(TaskEvent{Type: TaskEventTask, TaskID: "task-1"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+
+	n := 0
+	for range slow.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Fatalf("expected %d buffered events before drop, got %d", subscriberBuffer, n)
+	}
+	b.publish(TaskEvent{Type: TaskEventTask, TaskID: "task-2"})
+	if ev := <-other.C; ev.TaskID != "task-2" {
+		t.Fatalf("unexpected event for filtered subscriber %+v", ev)
+	}
+	b.unsubscribe(other)
+	b.unsubscribe(slow)
+}
+
+func TestEventBrokerResume(t *testing.T) {
+	b := newEventBroker()
+	for i := 0; i < 3; i++ {
+		b.publish(TaskEvent{Type: TaskEventTask, TaskID: "task-" + strconv.Itoa(i%2)})
+	}
+	_, missed, ok := b.subscribe("task-0", 1)
+	if !ok || len(missed) != 1 || missed[0].ID != 3 {
+		t.Fatalf("unexpected replay %+v %v", missed, ok)
+	}
+	if _, _, ok := b.subscribe("", 10); ok {
+		t.Fatal("expected snapshot for unknown event id")
+	}
+
+	for i := 0; i < eventHistory; i++ {
+		b.publish(TaskEvent{Type: TaskEventTask, TaskID: "task-0"})
+	}
+	if _, _, ok := b.subscribe("", 1); ok {
+		t.Fatal("expected snapshot when history no longer covers the gap")
+	}
+}
+
+// sseEvent — разобранное событие потока
+type sseEvent struct {
+	Type string
+	ID   uint64
+	Data TaskEvent
+}
+
+// readEvents читает события SSE из тела ответа в канал
+func readEvents(resp *http.Response) <-chan sseEvent {
+	ch := make(chan sseEvent, 100)
+	go func() {
+		defer close(ch)
+		sc := bufio.NewScanner(resp.Body)
+		var ev sseEvent
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.Type = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "id: "):
+				ev.ID, _ = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.Data)
+			case line == "" && ev.Type != "":
+				ch <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return ch
+}
+
+func TestTaskEventStream(t *testing.T) {
+	srv, _ := countingServer(t)
+	ts, mgr := setupTestServerLimits(2, 2)
+	defer ts.Close()
+	id, _ := mgr.Create()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/v1/tasks/"+id+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+	events := readEvents(resp)
+	if ev := <-events; ev.Type != TaskEventTask || ev.Data.Status != StatusPending {
+		t.Fatalf("expected snapshot first, got %+v", ev)
+	}
+
+	mgr.AddURL(id, srv.URL+"/a.txt")
+	mgr.AddURL(id, srv.URL+"/b.txt")
+	var lastID uint64
+	files := 0
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed early")
+			}
+			if ev.ID <= lastID {
+				t.Fatalf("event ids not increasing: %d after %d", ev.ID, lastID)
+			}
+			lastID = ev.ID
+			if ev.Type == TaskEventFile && ev.Data.File != nil && ev.Data.File.State == FileDone {
+				files++
+			}
+			done = ev.Type == TaskEventTask && ev.Data.Status == StatusComplete
+		case <-timeout:
+			t.Fatal("no completion event")
+		}
+	}
+	cancel()
+	resp.Body.Close()
+	if files != 2 {
+		t.Fatalf("expected done events for both files, got %d", files)
+	}
+
+	// повторное подключение с Last-Event-ID получает только новые события
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/v1/tasks/events", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("reopen stream: %v", err)
+	}
+	events = readEvents(resp)
+	mgr.Delete(id)
+	select {
+	case ev := <-events:
+		if ev.Type != TaskEventDeleted || ev.Data.TaskID != id || ev.ID != lastID+1 {
+			t.Fatalf("unexpected event after resume %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event after resume")
+	}
+	cancel()
+	resp.Body.Close()
+
+	resp, _ = http.Get(ts.URL + "/api/v1/tasks/missing/events")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown task, got %d", resp.StatusCode)
+	}
+}
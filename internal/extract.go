@@ -0,0 +1,321 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExtractLimits ограничивает распаковку вложенных архивов: во сколько раз
+// распакованные данные могут превышать скачанный архив, их общий размер
+// и число файлов. Нулевые поля заменяются значениями по умолчанию
+type ExtractLimits struct {
+	MaxRatio     int64
+	MaxTotalSize int64
+	MaxEntries   int
+}
+
+var defaultExtractLimits = ExtractLimits{MaxRatio: 100, MaxTotalSize: 1 << 30, MaxEntries: 10000}
+
+var errExtractLimit = errors.New("nested archive exceeds extraction limits")
+
+func (l ExtractLimits) withDefaults() ExtractLimits {
+	if l.MaxRatio <= 0 {
+		l.MaxRatio = defaultExtractLimits.MaxRatio
+	}
+	if l.MaxTotalSize <= 0 {
+		l.MaxTotalSize = defaultExtractLimits.MaxTotalSize
+	}
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = defaultExtractLimits.MaxEntries
+	}
+	return l
+}
+
+// SetExtractLimits задаёт ограничения распаковки вложенных архивов
+func (m *TaskManager) SetExtractLimits(l ExtractLimits) {
+	m.mu.Lock()
+	m.extractLimits = l.withDefaults()
+	m.mu.Unlock()
+}
+
+// extractedFile — файл вложенного архива, распакованный во временный каталог
+type extractedFile struct {
+	name     string
+	path     string
+	modified time.Time
+}
+
+// nestedArchive — распакованное содержимое вложенного архива
+type nestedArchive struct {
+	dir     string
+	files   []extractedFile
+	skipped map[string]string // файлы, отброшенные фильтром расширений
+	limit   int64
+	total   int64
+	limits  ExtractLimits
+	exts    map[string]struct{}
+}
+
+// nestedFormat определяет формат вложенного архива по сигнатуре: zip или tar.gz
+func nestedFormat(f *os.File) ArchiveFormat {
+	magic := make([]byte, 4)
+	n, _ := f.ReadAt(magic, 0)
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return FormatZip
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return FormatTarGz
+	}
+	return ""
+}
+
+// extractNested распаковывает архив src во временный каталог с проверкой
+// путей, ограничений размера и разрешённых расширений
+func (m *TaskManager) extractNested(src *os.File, size int64, format ArchiveFormat) (*nestedArchive, error) {
+	m.mu.Lock()
+	limits := m.extractLimits.withDefaults()
+	m.mu.Unlock()
+	dir, err := os.MkdirTemp("", "linkzipper-extract-*")
+	if err != nil {
+		return nil, err
+	}
+	na := &nestedArchive{
+		dir:     dir,
+		skipped: make(map[string]string),
+		limit:   limits.MaxTotalSize,
+		limits:  limits,
+		exts:    m.exts,
+	}
+	if ratio := limits.MaxRatio * size; ratio < na.limit {
+		na.limit = ratio
+	}
+	if format == FormatZip {
+		err = na.readZip(src, size)
+	} else {
+		err = na.readTarGz(io.NewSectionReader(src, 0, size))
+	}
+	if err != nil {
+		na.remove()
+		return nil, err
+	}
+	return na, nil
+}
+
+func (na *nestedArchive) readZip(src *os.File, size int64) error {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		err = na.add(f.Name, f.Modified, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (na *nestedArchive) readTarGz(src io.Reader) error {
+	gr, err := gzip.NewReader(src)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := na.add(hdr.Name, hdr.ModTime, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// add сохраняет один файл вложенного архива, считая распакованные байты
+// по факту, а не по заявленным в заголовках размерам
+func (na *nestedArchive) add(name string, modified time.Time, r io.Reader) error {
+	clean := sanitizePath(name)
+	if clean == "" {
+		return nil
+	}
+	ext := filepath.Ext(clean)
+	if _, allowed := na.exts[ext]; !allowed {
+		na.skipped[clean] = fmt.Sprintf("extension %s not allowed", ext)
+		return nil
+	}
+	for _, f := range na.files {
+		if f.name == clean {
+			return nil
+		}
+	}
+	if len(na.files) >= na.limits.MaxEntries {
+		return errExtractLimit
+	}
+	out, err := os.CreateTemp(na.dir, "entry-*")
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(r, na.limit-na.total+1))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	na.total += n
+	if na.total > na.limit {
+		return errExtractLimit
+	}
+	na.files = append(na.files, extractedFile{name: clean, path: out.Name(), modified: modified})
+	return nil
+}
+
+func (na *nestedArchive) remove() {
+	os.RemoveAll(na.dir)
+}
+
+// writeTo добавляет распакованные файлы в архив в каталог dir
+func (na *nestedArchive) writeTo(aw ArchiveWriter, dir string) error {
+	for _, f := range na.files {
+		w, err := aw.Create(dir+"/"+f.name, f.modified)
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(f.path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nestedDir возвращает имя каталога для содержимого вложенного архива name
+func nestedDir(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	if ext := filepath.Ext(name); ext != "" && len(name) > len(ext) {
+		return strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// addDownloaded пишет скачанный файл в архив, считая SHA-256 содержимого по
+// мере скачивания. Если seen не nil, файл с уже встречавшимся хешем в архив
+// не пишется и возвращается errDuplicate. Если настроен антивирус, файл
+// сначала проверяется. Если для ссылки включена распаковка и файл оказался
+// zip или tar.gz, его содержимое кладётся в каталог рядом с остальными
+// файлами. Возвращает имя записи; для распакованного архива — каталог с
+// завершающим слэшем
+func (m *TaskManager) addDownloaded(ctx context.Context, task *Task, aw ArchiveWriter, i int, name string, modified time.Time, body io.Reader, seen map[string]string) (string, error) {
+	m.mu.Lock()
+	scan := m.scanner != nil
+	m.mu.Unlock()
+	fp := task.Files[i]
+	h := sha256.New()
+	body = io.TeeReader(body, h)
+	extract := task.Options.Extract || task.Links[i].Extract
+	if !extract && !scan && seen == nil {
+		w, err := aw.Create(name, modified)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(w, body); err != nil {
+			return "", err
+		}
+		m.mu.Lock()
+		fp.SHA256 = hex.EncodeToString(h.Sum(nil))
+		m.mu.Unlock()
+		return name, nil
+	}
+	tmp, err := os.CreateTemp("", "linkzipper-nested-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, body)
+	if err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	m.mu.Lock()
+	fp.SHA256 = digest
+	m.mu.Unlock()
+	if entry, dup := seen[digest]; dup {
+		return "", &errDuplicate{entry: entry}
+	}
+	if scan {
+		if err := m.scanFile(ctx, task, i, tmp); err != nil {
+			return "", err
+		}
+	}
+	var format ArchiveFormat
+	if extract {
+		format = nestedFormat(tmp)
+	}
+	if format == "" {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		w, err := aw.Create(name, modified)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(w, tmp)
+		return name, err
+	}
+	na, err := m.extractNested(tmp, size, format)
+	if err != nil {
+		return "", err
+	}
+	defer na.remove()
+	url := task.Urls[i]
+	m.mu.Lock()
+	for inner, msg := range na.skipped {
+		task.Errors[url+"#"+inner] = msg
+	}
+	m.mu.Unlock()
+	dir := nestedDir(name)
+	if err := na.writeTo(aw, dir); err != nil {
+		return "", err
+	}
+	return dir + "/", nil
+}
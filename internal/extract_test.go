@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func buildZip(files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func buildTarGz(files map[string]string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+func extractBytes(t *testing.T, mgr *TaskManager, data []byte) (*nestedArchive, error) {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write(data)
+	format := nestedFormat(f)
+	if format == "" {
+		t.Fatal("archive not detected")
+	}
+	return mgr.extractNested(f, int64(len(data)), format)
+}
+
+func TestExtractNestedSanitized(t *testing.T) {
+	files := map[string]string{
+		"../../evil.txt":    "evil",
+		"/abs/root.txt":     "root",
+		"docs/./ok.txt":     "ok",
+		`..\win\bad.txt`:    "win",
+		"payload.exe":       "exe",
+		"docs/../again.txt": "again",
+	}
+	mgr := NewManager(1, 1, []string{".txt"})
+	for _, data := range [][]byte{buildZip(files), buildTarGz(files)} {
+		na, err := extractBytes(t, mgr, data)
+		if err != nil {
+			t.Fatalf("extract: %v", err)
+		}
+		got := make(map[string]bool)
+		for _, f := range na.files {
+			got[f.name] = true
+			if !strings.HasPrefix(f.path, na.dir) {
+				t.Fatalf("file written outside extraction dir: %s", f.path)
+			}
+		}
+		for _, want := range []string{"evil.txt", "abs/root.txt", "docs/ok.txt", "win/bad.txt", "docs/again.txt"} {
+			if !got[want] {
+				t.Fatalf("missing %s in %v", want, got)
+			}
+		}
+		if len(got) != 5 || na.skipped["payload.exe"] == "" {
+			t.Fatalf("unexpected result %v, skipped %v", got, na.skipped)
+		}
+		na.remove()
+	}
+}
+
+func TestExtractNestedLimits(t *testing.T) {
+	bomb := map[string]string{"zeros.txt": strings.Repeat("\x00", 1<<20)}
+	mgr := NewManager(1, 1, []string{".txt"})
+	mgr.SetExtractLimits(ExtractLimits{MaxRatio: 10})
+	for _, data := range [][]byte{buildZip(bomb), buildTarGz(bomb)} {
+		if _, err := extractBytes(t, mgr, data); err != errExtractLimit {
+			t.Fatalf("expected ratio limit error, got %v", err)
+		}
+	}
+
+	mgr.SetExtractLimits(ExtractLimits{MaxTotalSize: 1000})
+	big := map[string]string{"a.txt": strings.Repeat("x", 600), "b.txt": strings.Repeat("y", 600)}
+	if _, err := extractBytes(t, mgr, buildZip(big)); err != errExtractLimit {
+		t.Fatalf("expected total size error, got %v", err)
+	}
+
+	mgr.SetExtractLimits(ExtractLimits{MaxEntries: 2})
+	many := map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"}
+	if _, err := extractBytes(t, mgr, buildTarGz(many)); err != errExtractLimit {
+		t.Fatalf("expected entries limit error, got %v", err)
+	}
+}
+
+func TestTaskExtract(t *testing.T) {
+	bundle := buildZip(map[string]string{"inner/a.txt": "a", "b.txt": "b", "skip.exe": "x"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bundle.zip", "/copy.zip":
+			w.Write(bundle)
+		default:
+			w.Write([]byte("plain"))
+		}
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(2, 3, []string{".txt", ".zip"})
+	id, _ := mgr.CreateWithOptions(TaskOptions{Format: FormatTar})
+	mgr.AddLink(id, Link{URL: srv.URL + "/bundle.zip", Extract: true})
+	mgr.AddURL(id, srv.URL+"/copy.zip")
+	mgr.AddURL(id, srv.URL+"/plain.txt")
+	task := waitVersion(t, mgr, id, 1)
+
+	files := readVersion(t, mgr, task, FormatTar, 1)
+	want := map[string]string{"bundle/inner/a.txt": "a", "bundle/b.txt": "b", "plain.txt": "plain"}
+	for name, content := range want {
+		if files[name] != content {
+			t.Fatalf("entry %s: got %q in %v", name, files[name], files)
+		}
+	}
+	if len(files) != 4 || !strings.HasPrefix(files["copy.zip"], "PK") {
+		t.Fatalf("expected copy.zip kept as is, got %v", files)
+	}
+	if task.Errors[srv.URL+"/bundle.zip#skip.exe"] == "" {
+		t.Fatalf("expected skipped inner file in errors, got %v", task.Errors)
+	}
+
+	// новая версия копирует распакованный каталог целиком
+	mgr.AddURL(id, srv.URL+"/late.txt")
+	mgr.ForceZip(id)
+	task = waitVersion(t, mgr, id, 2)
+	files = readVersion(t, mgr, task, FormatTar, 2)
+	if len(files) != 5 || files["bundle/inner/a.txt"] != "a" || files["late.txt"] != "plain" {
+		t.Fatalf("unexpected version 2 contents %v", files)
+	}
+	mgr.Delete(id)
+}
+
+func TestEncryptedTaskExtract(t *testing.T) {
+	bundle := buildZip(map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c", "d.txt": "d"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bundle.zip" {
+			w.Write(bundle)
+			return
+		}
+		w.Write([]byte("same"))
+	}))
+	defer srv.Close()
+
+	// записей больше, чем ссылок: четыре распакованных файла и manifest.json
+	mgr := NewManager(2, 3, []string{".txt", ".zip"})
+	id, _ := mgr.CreateWithOptions(TaskOptions{Encrypt: true, Password: "p", Extract: true, Dedup: DedupAlias})
+	mgr.AddURL(id, srv.URL+"/bundle.zip")
+	mgr.AddURL(id, srv.URL+"/x.txt")
+	mgr.AddURL(id, srv.URL+"/y.txt")
+	task := waitVersion(t, mgr, id, 1)
+	defer mgr.Delete(id)
+	if len(task.Errors) != 0 {
+		t.Fatalf("unexpected errors %v", task.Errors)
+	}
+
+	zr, err := zip.OpenReader(task.ZipPath)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer zr.Close()
+	got := make(map[string]string)
+	for _, f := range zr.File {
+		got[f.Name] = string(decryptAESEntry(t, f, "p"))
+	}
+	if len(got) != 6 || got["bundle/d.txt"] != "d" || got["x.txt"] != "same" || !strings.Contains(got[manifestName], "y.txt") {
+		t.Fatalf("unexpected archive contents %v", got)
+	}
+}
+
+func TestNestedDir(t *testing.T) {
+	cases := map[string]string{
+		"bundle.zip":      "bundle",
+		"a/b/data.TAR.GZ": "a/b/data",
+		"x.tgz":           "x",
+		"notes.bin":       "notes",
+		".zip":            ".zip",
+	}
+	for in, want := range cases {
+		if got := nestedDir(in); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+}
@@ -1,10 +1,19 @@
 package internal
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
-	"path"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,94 +22,596 @@ type API struct {
 }
 
 func (api *API) CreateTask(w http.ResponseWriter, r *http.Request) {
-	id, err := api.Manager.Create()
+	var req struct {
+		TaskOptions
+		Password string `json:"password"`
+		Links    []Link `json:"links"`
+		Start    bool   `json:"start"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		Logger.WithError(err).Error("invalid create task request")
+		writeError(w, err, nil)
+		return
+	}
+	opts := req.TaskOptions
+	opts.Password = req.Password
+	generated := opts.Encrypt && opts.Password == ""
+	if generated {
+		opts.Password = GeneratePassword()
+	}
+	if err := api.Manager.ValidateOptions(opts); err != nil {
+		Logger.WithError(err).Error("invalid task options")
+		writeError(w, err, nil)
+		return
+	}
+	if req.Start && len(req.Links) == 0 {
+		Logger.Error("start requested without links")
+		writeError(w, ErrNoFiles, nil)
+		return
+	}
+	id, linkErrs, err := api.Manager.CreateBatch(opts, req.Links, req.Start)
 	if err != nil {
 		Logger.WithError(err).Error("failed to create task")
-		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		writeError(w, err, linkDetails(linkErrs))
 		return
 	}
-	json.NewEncoder(w).Encode(map[string]string{"task_id": id})
+	out := map[string]string{"task_id": id}
+	if generated {
+		out["password"] = opts.Password
+	}
+	writeJSON(w, out)
 }
 
+// AddLink добавляет ссылку в задачу; ID задачи берётся из маршрута, а в
+// устаревшем POST /tasks/links — из поля task_id
 func (api *API) AddLink(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		TaskID string `json:"task_id"`
-		URL    string `json:"url"`
+		Link
 	}
-	json.NewDecoder(r.Body).Decode(&req)
-	if err := api.Manager.AddURL(req.TaskID, req.URL); err != nil {
+	if err := decodeJSON(w, r, &req); err != nil {
+		Logger.WithError(err).Error("invalid add link request")
+		writeError(w, err, nil)
+		return
+	}
+	if id := chi.URLParam(r, "id"); id != "" {
+		req.TaskID = id
+	}
+	if err := api.Manager.AddLink(req.TaskID, req.Link); err != nil {
 		Logger.WithError(err).WithField("task_id", req.TaskID).Error("failed to add link")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, nil)
 		return
 	}
 	Logger.WithFields(logrus.Fields{"task_id": req.TaskID, "url": req.URL}).Info("link added")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// RemoveLink убирает ссылку из ожидающей задачи. Ссылка задаётся номером
+// в списке urls или самим URL, экранированным для пути
+func (api *API) RemoveLink(w http.ResponseWriter, r *http.Request) {
+	id, ref := chi.URLParam(r, "id"), linkRef(r)
+	if err := api.Manager.RemoveLink(id, ref); err != nil {
+		Logger.WithError(err).WithField("task_id", id).Error("failed to remove link")
+		writeError(w, err, nil)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// ReplaceLink заменяет ссылку ожидающей задачи на ссылку из тела запроса
+func (api *API) ReplaceLink(w http.ResponseWriter, r *http.Request) {
+	var link Link
+	if err := decodeJSON(w, r, &link); err != nil {
+		Logger.WithError(err).Error("invalid replace link request")
+		writeError(w, err, nil)
+		return
+	}
+	id, ref := chi.URLParam(r, "id"), linkRef(r)
+	if err := api.Manager.ReplaceLink(id, ref, link); err != nil {
+		Logger.WithError(err).WithField("task_id", id).Error("failed to replace link")
+		writeError(w, err, nil)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// linkRef возвращает ссылку из маршрута; URL со слэшами приходит экранированным
+func linkRef(r *http.Request) string {
+	ref := chi.URLParam(r, "ref")
+	if r.URL.RawPath != "" {
+		if u, err := neturl.PathUnescape(ref); err == nil {
+			ref = u
+		}
+	}
+	return ref
 }
 
 func (api *API) ListTasks(w http.ResponseWriter, r *http.Request) {
 	tasks := api.Manager.List()
 	resp := make([]map[string]interface{}, 0, len(tasks))
 	for _, t := range tasks {
+		files, progress := api.Manager.Progress(t)
 		resp = append(resp, map[string]interface{}{
-			"id":     t.ID,
-			"status": t.Status,
-			"errors": t.Errors,
-			"urls":   t.Urls,
+			"id":       t.ID,
+			"status":   t.Status,
+			"format":   t.Options.Format,
+			"options":  t.Options,
+			"errors":   t.Errors,
+			"urls":     t.Urls,
+			"links":    t.Links,
+			"files":    files,
+			"progress": progress,
 		})
 	}
 	Logger.Info("tasks listed")
-	json.NewEncoder(w).Encode(resp)
+	writeJSON(w, resp)
 }
 
+// ForceZip запускает сборку архива; ID задачи берётся из маршрута, а в
+// устаревшем POST /tasks/zip — из поля task_id
 func (api *API) ForceZip(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		TaskID string `json:"task_id"`
 	}
-	json.NewDecoder(r.Body).Decode(&req)
+	if err := decodeJSON(w, r, &req); err != nil {
+		Logger.WithError(err).Error("invalid force zip request")
+		writeError(w, err, nil)
+		return
+	}
+	if id := chi.URLParam(r, "id"); id != "" {
+		req.TaskID = id
+	}
 	if err := api.Manager.ForceZip(req.TaskID); err != nil {
 		Logger.WithError(err).WithField("task_id", req.TaskID).Error("force zip failed")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, nil)
 		return
 	}
 	Logger.WithField("task_id", req.TaskID).Info("force zip started")
-	json.NewEncoder(w).Encode(map[string]string{"status": "processing"})
+	writeJSON(w, map[string]string{"status": "processing"})
 }
 
 func (api *API) DeleteTask(w http.ResponseWriter, r *http.Request) {
-	id := path.Base(r.URL.Path)
+	id := chi.URLParam(r, "id")
 	if err := api.Manager.Delete(id); err != nil {
 		Logger.WithError(err).WithField("task_id", id).Error("delete task failed")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, nil)
 		return
 	}
 	Logger.WithField("task_id", id).Info("task deleted via API")
-	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+	writeJSON(w, map[string]string{"status": "deleted"})
+}
+
+func (api *API) CancelTask(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := api.Manager.Cancel(id); err != nil {
+		Logger.WithError(err).WithField("task_id", id).Error("cancel task failed")
+		writeError(w, err, nil)
+		return
+	}
+	Logger.WithField("task_id", id).Info("task cancelled via API")
+	writeJSON(w, map[string]string{"status": "ok"})
 }
 
 func (api *API) GetStatus(w http.ResponseWriter, r *http.Request) {
-	id := path.Base(r.URL.Path)
+	id := chi.URLParam(r, "id")
 	task, err := api.Manager.Status(id)
 	if err != nil {
 		Logger.WithError(err).WithField("task_id", id).Error("status request failed")
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, err, nil)
 		return
 	}
 	Logger.WithField("task_id", id).Info("status requested")
-	out := map[string]interface{}{"status": task.Status, "errors": task.Errors, "urls": task.Urls}
+	files, progress := api.Manager.Progress(task)
+	out := map[string]interface{}{
+		"status":   task.Status,
+		"format":   task.Options.Format,
+		"options":  task.Options,
+		"errors":   task.Errors,
+		"urls":     task.Urls,
+		"links":    task.Links,
+		"files":    files,
+		"progress": progress,
+	}
 	if task.Status == StatusComplete {
-		out["archive_url"] = "/download/" + id
+		out["sha256"] = hex.EncodeToString(task.Digest)
+		if task.Signature != nil {
+			out["signature_url"] = signatureURL(r, id)
+		}
+		if task.Options.MaxPartSize > 0 {
+			out["parts"] = partsInfo(archiveURL(r, id), task.Parts, "")
+		} else {
+			out["archive_url"] = archiveURL(r, id)
+		}
+	}
+	if task.Failure != "" {
+		out["failure"] = task.Failure
+	}
+	if dups := duplicates(files); len(dups) > 0 {
+		out["deduplicated"] = dups
+	}
+	if task.Version > 0 {
+		out["version"] = task.Version
+		out["versions"] = versionsInfo(r, task)
 	}
-	json.NewEncoder(w).Encode(out)
+	writeJSON(w, out)
 }
 
-func (api *API) Download(w http.ResponseWriter, r *http.Request) {
-	id := path.Base(r.URL.Path)
+// Deliveries отдаёт журнал доставок вебхуков задачи
+func (api *API) Deliveries(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	deliveries, err := api.Manager.Deliveries(id)
+	if err != nil {
+		Logger.WithError(err).WithField("task_id", id).Error("deliveries request failed")
+		writeError(w, err, nil)
+		return
+	}
+	writeJSON(w, deliveries)
+}
+
+func partsInfo(url string, archiveParts []ArchivePart, query string) []map[string]interface{} {
+	parts := make([]map[string]interface{}, 0, len(archiveParts))
+	for i, p := range archiveParts {
+		parts = append(parts, map[string]interface{}{
+			"part": i + 1,
+			"size": p.Size,
+			"url":  fmt.Sprintf("%s?%spart=%d", url, query, i+1),
+		})
+	}
+	return parts
+}
+
+// versionsInfo описывает все сохранённые версии архива, включая текущую
+func versionsInfo(r *http.Request, task *Task) []map[string]interface{} {
+	all := append(append([]ArchiveVersion{}, task.Versions...), currentVersion(task))
+	out := make([]map[string]interface{}, 0, len(all))
+	for _, v := range all {
+		query := fmt.Sprintf("version=%d", v.Number)
+		info := map[string]interface{}{
+			"version": v.Number,
+			"sha256":  hex.EncodeToString(v.Digest),
+		}
+		if v.ZipPath == "" {
+			info["parts"] = partsInfo(archiveURL(r, task.ID), v.Parts, query+"&")
+		} else {
+			info["archive_url"] = archiveURL(r, task.ID) + "?" + query
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// archiveVersion находит версию архива по параметру version; без него — текущую.
+// Предыдущие версии доступны и пока задача дополняется новыми ссылками
+func (api *API) archiveVersion(r *http.Request, id string) (*Task, ArchiveVersion, error) {
+	n := 0
+	if q := r.URL.Query(); q.Has("version") {
+		var err error
+		if n, err = strconv.Atoi(q.Get("version")); err != nil || n < 1 {
+			return nil, ArchiveVersion{}, invalidf("version must be a positive integer")
+		}
+	}
 	task, err := api.Manager.Status(id)
-	if err != nil || task.Status != StatusComplete {
-		Logger.WithField("task_id", id).Error("download requested before ready")
-		http.Error(w, "not ready", http.StatusBadRequest)
+	if err != nil {
+		return nil, ArchiveVersion{}, err
+	}
+	if n == 0 && task.Status != StatusComplete {
+		return nil, ArchiveVersion{}, ErrNotReady
+	}
+	if task.Version == 0 {
+		return nil, ArchiveVersion{}, ErrNotReady
+	}
+	v, err := api.Manager.Version(task, n)
+	return task, v, err
+}
+
+func (api *API) Download(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !isV1(r) && strings.HasSuffix(id, sigExt) {
+		api.downloadSignature(w, r, strings.TrimSuffix(id, sigExt))
 		return
 	}
+	task, v, err := api.archiveVersion(r, id)
+	if err != nil {
+		Logger.WithError(err).WithField("task_id", id).Error("download requested before ready")
+		writeError(w, err, nil)
+		return
+	}
+	filePath := v.ZipPath
+	fileName := id + task.Options.ArchiveExt()
+	if v.Number != task.Version {
+		fileName = fmt.Sprintf("%s-v%d%s", id, v.Number, task.Options.ArchiveExt())
+	}
+	contentType := task.Options.ContentType()
+	var archive io.ReadSeekCloser
+	if p := r.URL.Query().Get("part"); p != "" {
+		n, _ := strconv.Atoi(p)
+		archive, err = api.Manager.OpenPart(v, n)
+		if err != nil {
+			Logger.WithError(err).WithField("task_id", id).Error("open part failed")
+			writeError(w, err, nil)
+			return
+		}
+		filePath = v.Parts[n-1].Path
+		fileName += fmt.Sprintf(".%03d", n)
+		contentType = "application/octet-stream"
+	} else {
+		archive, err = api.Manager.OpenArchive(v)
+		if err != nil {
+			Logger.WithError(err).WithField("task_id", id).Error("open archive failed")
+			writeError(w, err, nil)
+			return
+		}
+		if v.Digest != nil {
+			digest := base64.StdEncoding.EncodeToString(v.Digest)
+			w.Header().Set("Digest", "sha-256="+digest)
+			w.Header().Set("Repr-Digest", "sha-256=:"+digest+":")
+		}
+	}
+	defer archive.Close()
 	Logger.WithField("task_id", id).Info("download started")
-	http.ServeFile(w, r, task.ZipPath)
+	var modified time.Time
+	if st, err := os.Stat(filePath); err == nil {
+		modified = st.ModTime()
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	http.ServeContent(w, r, "", modified, archive)
+}
+
+// DownloadSignature отдаёт отделённую подпись архива задачи
+func (api *API) DownloadSignature(w http.ResponseWriter, r *http.Request) {
+	api.downloadSignature(w, r, chi.URLParam(r, "id"))
+}
+
+// downloadSignature отдаёт отделённую подпись архива в base64
+func (api *API) downloadSignature(w http.ResponseWriter, r *http.Request, id string) {
+	task, v, err := api.archiveVersion(r, id)
+	if err == nil && v.Signature == nil {
+		err = ErrSignatureMissing
+	}
+	if err != nil {
+		Logger.WithError(err).WithField("task_id", id).Error("signature not available")
+		writeError(w, err, nil)
+		return
+	}
+	Logger.WithField("task_id", id).Info("signature downloaded")
+	fileName := id + task.Options.ArchiveExt()
+	if v.Number != task.Version {
+		fileName = fmt.Sprintf("%s-v%d%s", id, v.Number, task.Options.ArchiveExt())
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName+sigExt))
+	fmt.Fprintln(w, base64.StdEncoding.EncodeToString(v.Signature))
+}
+
+// SigningKey отдаёт открытый ключ Ed25519, которым подписываются архивы
+func (api *API) SigningKey(w http.ResponseWriter, r *http.Request) {
+	signer := api.Manager.Signer()
+	if signer == nil {
+		writeError(w, ErrSigningDisabled, nil)
+		return
+	}
+	writeJSON(w, map[string]string{
+		"algorithm":  "ed25519",
+		"public_key": base64.StdEncoding.EncodeToString(signer.PublicKey()),
+	})
+}
+
+// VerifyArchive проверяет загруженный архив по подписи: multipart-форма
+// с файлом archive и полем signature (base64, как в /download/{id}.sig)
+func (api *API) VerifyArchive(w http.ResponseWriter, r *http.Request) {
+	signer := api.Manager.Signer()
+	if signer == nil {
+		writeError(w, ErrSigningDisabled, nil)
+		return
+	}
+	sig, err := DecodeSignature(r.FormValue("signature"))
+	if err != nil {
+		writeError(w, err, nil)
+		return
+	}
+	file, _, err := r.FormFile("archive")
+	if err != nil {
+		writeError(w, invalidf("archive file required"), nil)
+		return
+	}
+	defer file.Close()
+	digest, err := VerifyArchive(signer.PublicKey(), file, sig)
+	out := map[string]interface{}{"valid": err == nil, "sha256": hex.EncodeToString(digest)}
+	Logger.WithField("valid", err == nil).Info("archive verified")
+	writeJSON(w, out)
+}
+
+// StreamZip отдаёт zip по списку ссылок сразу в ответ: POST с {"urls": [...]}
+// или GET с повторяющимся параметром url
+func (api *API) StreamZip(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URLs []string `json:"urls"`
+	}
+	if r.Method == http.MethodGet {
+		req.URLs = r.URL.Query()["url"]
+	} else if err := decodeJSON(w, r, &req); err != nil {
+		Logger.WithError(err).Error("invalid stream zip request")
+		writeError(w, err, nil)
+		return
+	}
+	if err := api.Manager.ValidateLinks(req.URLs); err != nil {
+		Logger.WithError(err).Error("stream zip rejected")
+		writeError(w, err, nil)
+		return
+	}
+	sw := &streamWriter{w: w}
+	if err := api.Manager.Stream(r.Context(), req.URLs, sw); err != nil && !sw.started {
+		writeError(w, err, nil)
+	}
+}
+
+// streamWriter отправляет заголовки архива только при первой записи,
+// чтобы до начала потока ещё можно было ответить ошибкой
+type streamWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if !sw.started {
+		sw.started = true
+		sw.w.Header().Set("Content-Type", "application/zip")
+		sw.w.Header().Set("Content-Disposition", `attachment; filename="linkzipper.zip"`)
+	}
+	n, err := sw.w.Write(p)
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// CreateJob принимает произвольно длинный список ссылок ("urls" или
+// "links") вместе с опциями задач и делит его на задачи по лимиту файлов
+func (api *API) CreateJob(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TaskOptions
+		URLs  []string `json:"urls"`
+		Links []Link   `json:"links"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		Logger.WithError(err).Error("invalid create job request")
+		writeError(w, err, nil)
+		return
+	}
+	links := req.Links
+	for _, url := range req.URLs {
+		links = append(links, Link{URL: url})
+	}
+	id, chunks, linkErrs, err := api.Manager.CreateJob(req.TaskOptions, links)
+	if err != nil {
+		Logger.WithError(err).Error("failed to create job")
+		writeError(w, err, linkDetails(linkErrs))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"job_id": id, "tasks": chunks})
+}
+
+func (api *API) GetJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	job, err := api.Manager.Job(id)
+	if err != nil {
+		Logger.WithError(err).WithField("job_id", id).Error("job status request failed")
+		writeError(w, err, nil)
+		return
+	}
+	tasks, status, progress, failure := api.Manager.JobProgress(job)
+	out := map[string]interface{}{
+		"job_id":   id,
+		"status":   status,
+		"progress": progress,
+		"links":    len(job.Links),
+		"tasks":    tasks,
+		"pending":  job.chunks - len(tasks),
+	}
+	if failure != "" {
+		out["failure"] = failure
+	}
+	if status == StatusComplete {
+		out["download_url"] = jobArchiveURL(r, id)
+		out["merged_url"] = jobArchiveURL(r, id) + "?merge=true"
+	}
+	writeJSON(w, out)
+}
+
+// DownloadJob отдаёт zip с архивами всех задач задания, а с ?merge=true —
+// один архив со всеми файлами в формате задания
+func (api *API) DownloadJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	merge, _ := strconv.ParseBool(r.URL.Query().Get("merge"))
+	job, tasks, err := api.Manager.JobArchives(id, merge)
+	if err != nil {
+		Logger.WithError(err).WithField("job_id", id).Error("job download requested before ready")
+		writeError(w, err, nil)
+		return
+	}
+	Logger.WithFields(logrus.Fields{"job_id": id, "merge": merge}).Info("job download started")
+	if merge {
+		w.Header().Set("Content-Type", job.Options.Format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, id+job.Options.Format.Ext()))
+		err = api.Manager.WriteJobMerged(w, job, tasks)
+	} else {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-archives.zip"`, id))
+		err = api.Manager.WriteJobBundle(w, tasks)
+	}
+	if err != nil {
+		Logger.WithError(err).WithField("job_id", id).Error("job download failed")
+	}
+}
+
+// sseKeepAlive — интервал комментариев, которые не дают прокси закрыть
+// простаивающий поток событий
+var sseKeepAlive = 15 * time.Second
+
+// TaskEvents отдаёт поток Server-Sent Events об одной задаче
+func (api *API) TaskEvents(w http.ResponseWriter, r *http.Request) {
+	api.streamEvents(w, r, chi.URLParam(r, "id"))
+}
+
+// AllTaskEvents отдаёт поток Server-Sent Events обо всех задачах
+func (api *API) AllTaskEvents(w http.ResponseWriter, r *http.Request) {
+	api.streamEvents(w, r, "")
+}
+
+// streamEvents подписывается на события задач и пишет их в формате SSE.
+// При переподключении с Last-Event-ID досылаются пропущенные события, а
+// если они уже вытеснены из истории — текущее состояние задач
+func (api *API) streamEvents(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, fmt.Errorf("streaming is not supported"), nil)
+		return
+	}
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	sub, initial, err := api.Manager.Subscribe(id, lastID)
+	if err != nil {
+		Logger.WithError(err).WithField("task_id", id).Error("event stream request failed")
+		writeError(w, err, nil)
+		return
+	}
+	defer api.Manager.Unsubscribe(sub)
+	Logger.WithFields(logrus.Fields{"task_id": id, "last_event_id": lastID}).Info("event stream opened")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, ev := range initial {
+		writeEvent(w, ev)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case ev, ok := <-sub.C:
+			if !ok {
+				// подписчик отстал: клиент переподключится с Last-Event-ID
+				return
+			}
+			writeEvent(w, ev)
+			if ev.Type == TaskEventDeleted && id != "" {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent пишет событие в формате SSE: тип, номер для Last-Event-ID и JSON
+func writeEvent(w io.Writer, ev TaskEvent) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "event: %s\nid: %d\ndata: %s\n\n", ev.Type, ev.ID, data)
 }
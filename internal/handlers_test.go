@@ -1,14 +1,24 @@
 package internal
 
 import (
+	"archive/zip"
 	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"filippo.io/age"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -20,13 +30,7 @@ func setupTestServerLimits(maxTasks, maxFiles int) (*httptest.Server, *TaskManag
 	mgr := NewManager(maxTasks, maxFiles, []string{".txt"})
 	api := &API{Manager: mgr}
 	r := chi.NewRouter()
-	r.Post("/tasks", api.CreateTask)
-	r.Get("/tasks/list", api.ListTasks)
-	r.Post("/tasks/links", api.AddLink)
-	r.Post("/tasks/zip", api.ForceZip)
-	r.Delete("/tasks/delete/*", api.DeleteTask)
-	r.Get("/tasks/status/*", api.GetStatus)
-	r.Get("/download/*", api.Download)
+	api.Mount(r)
 	ts := httptest.NewServer(r)
 	return ts, mgr
 }
@@ -133,6 +137,12 @@ func TestAddLinksAndStatus(t *testing.T) {
 	if status["status"] != string(StatusPending) {
 		t.Fatalf("expected pending, got %v", status["status"])
 	}
+	if files, ok := status["files"].([]interface{}); !ok || len(files) != 1 {
+		t.Fatalf("expected 1 file in progress, got %v", status["files"])
+	}
+	if status["progress"] != float64(0) {
+		t.Fatalf("expected 0 progress, got %v", status["progress"])
+	}
 	stResp.Body.Close()
 
 	body, _ = json.Marshal(map[string]string{"task_id": id, "url": fileSrv.URL + "/f2.txt"})
@@ -272,3 +282,811 @@ func TestListDeleteAndForceZip(t *testing.T) {
 		t.Fatalf("expected 1 task after delete, got %d", len(list))
 	}
 }
+
+func TestCancelTaskEndpoint(t *testing.T) {
+	ts, mgr := setupTestServer()
+	defer ts.Close()
+
+	resp, _ := http.Post(ts.URL+"/tasks", "application/json", nil)
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+
+	resp, err := http.Post(ts.URL+"/tasks/"+out["task_id"]+"/cancel", "application/json", nil)
+	if err != nil {
+		t.Fatalf("cancel request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	task, _ := mgr.Status(out["task_id"])
+	if task.Status != StatusCancelled {
+		t.Fatalf("expected cancelled, got %s", task.Status)
+	}
+
+	resp, err = http.Post(ts.URL+"/tasks/missing/cancel", "application/json", nil)
+	if err != nil {
+		t.Fatalf("cancel request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestStreamZipEndpoint(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer fileSrv.Close()
+
+	ts, _ := setupTestServer()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/zip?url=" + fileSrv.URL + "/a.txt&url=" + fileSrv.URL + "/b.txt")
+	if err != nil {
+		t.Fatalf("stream request: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("unexpected response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	if len(zr.File) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(zr.File))
+	}
+
+	body, _ := json.Marshal(map[string][]string{"urls": {fileSrv.URL + "/a.exe"}})
+	resp, err = http.Post(ts.URL+"/zip", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("stream request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", resp.StatusCode)
+	}
+}
+
+func TestDownloadFormat(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer fileSrv.Close()
+
+	ts, _ := setupTestServerLimits(5, 1)
+	defer ts.Close()
+
+	resp, _ := http.Post(ts.URL+"/tasks", "application/json", bytes.NewReader([]byte(`{"format":"rar"}`)))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for unsupported format, got %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Post(ts.URL+"/tasks", "application/json", bytes.NewReader([]byte(`{"format":"tar"}`)))
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	id := out["task_id"]
+
+	body, _ := json.Marshal(map[string]string{"task_id": id, "url": fileSrv.URL + "/f.txt"})
+	resp, _ = http.Post(ts.URL+"/tasks/links", "application/json", bytes.NewReader(body))
+	resp.Body.Close()
+
+	for i := 0; i < 40; i++ {
+		resp, err := http.Get(ts.URL + "/download/" + id)
+		if err != nil {
+			t.Fatalf("download: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if ct := resp.Header.Get("Content-Type"); ct != "application/x-tar" {
+				t.Fatalf("unexpected content type %s", ct)
+			}
+			if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename="`+id+`.tar"` {
+				t.Fatalf("unexpected content disposition %s", cd)
+			}
+			if files := readArchive(t, FormatTar, data); files["f.txt"] != "ok" {
+				t.Fatalf("unexpected contents %v", files)
+			}
+			return
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatal("archive not ready")
+}
+
+func TestDownloadDigest(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer fileSrv.Close()
+
+	ts, _ := setupTestServerLimits(5, 1)
+	defer ts.Close()
+
+	resp, _ := http.Post(ts.URL+"/tasks", "application/json", bytes.NewReader([]byte(`{"deterministic":true}`)))
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	id := out["task_id"]
+
+	body, _ := json.Marshal(map[string]string{"task_id": id, "url": fileSrv.URL + "/f.txt"})
+	resp, _ = http.Post(ts.URL+"/tasks/links", "application/json", bytes.NewReader(body))
+	resp.Body.Close()
+
+	for i := 0; i < 40; i++ {
+		resp, err := http.Get(ts.URL + "/download/" + id)
+		if err != nil {
+			t.Fatalf("download: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			sum := sha256.Sum256(data)
+			digest := base64.StdEncoding.EncodeToString(sum[:])
+			if got := resp.Header.Get("Digest"); got != "sha-256="+digest {
+				t.Fatalf("unexpected Digest %s", got)
+			}
+			if got := resp.Header.Get("Repr-Digest"); got != "sha-256=:"+digest+":" {
+				t.Fatalf("unexpected Repr-Digest %s", got)
+			}
+
+			resp, _ = http.Get(ts.URL + "/tasks/status/" + id)
+			var status map[string]interface{}
+			json.NewDecoder(resp.Body).Decode(&status)
+			resp.Body.Close()
+			if status["sha256"] != hex.EncodeToString(sum[:]) {
+				t.Fatalf("unexpected sha256 %v", status["sha256"])
+			}
+			return
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatal("archive not ready")
+}
+
+func TestSignedArchive(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer fileSrv.Close()
+
+	ts, mgr := setupTestServerLimits(5, 1)
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/.well-known/linkzipper-signing-key")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 without signing key, got %d", resp.StatusCode)
+	}
+	signer, _ := NewSigner(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	mgr.SetSigner(signer)
+
+	resp, _ = http.Get(ts.URL + "/.well-known/linkzipper-signing-key")
+	var key map[string]string
+	json.NewDecoder(resp.Body).Decode(&key)
+	resp.Body.Close()
+	pub, _ := base64.StdEncoding.DecodeString(key["public_key"])
+	if key["algorithm"] != "ed25519" || !bytes.Equal(pub, signer.PublicKey()) {
+		t.Fatalf("unexpected key response %v", key)
+	}
+
+	id, _ := mgr.Create()
+	mgr.AddURL(id, fileSrv.URL+"/f.txt")
+	var archive []byte
+	for i := 0; i < 40; i++ {
+		resp, _ := http.Get(ts.URL + "/download/" + id)
+		archive, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	resp, _ = http.Get(ts.URL + "/download/" + id + ".sig")
+	sigText, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("signature: status %d", resp.StatusCode)
+	}
+	sig, err := DecodeSignature(string(sigText))
+	if err != nil {
+		t.Fatalf("decode signature: %v", err)
+	}
+	sum := sha256.Sum256(archive)
+	if !ed25519.Verify(pub, sum[:], sig) {
+		t.Fatal("signature does not verify")
+	}
+
+	verify := func(data []byte) map[string]interface{} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("signature", string(sigText))
+		fw, _ := mw.CreateFormFile("archive", "a.zip")
+		fw.Write(data)
+		mw.Close()
+		resp, _ := http.Post(ts.URL+"/verify", mw.FormDataContentType(), &body)
+		var out map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		return out
+	}
+	if out := verify(archive); out["valid"] != true || out["sha256"] != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected valid archive, got %v", out)
+	}
+	tampered := append([]byte{}, archive...)
+	tampered[0] ^= 1
+	if out := verify(tampered); out["valid"] != false {
+		t.Fatalf("expected tampered archive to fail, got %v", out)
+	}
+}
+
+func TestDownloadVersions(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer fileSrv.Close()
+
+	ts, mgr := setupTestServerLimits(5, 1)
+	defer ts.Close()
+
+	id, _ := mgr.Create()
+	for i, name := range []string{"/a.txt", "/b.txt"} {
+		body, _ := json.Marshal(map[string]string{"task_id": id, "url": fileSrv.URL + name})
+		resp, _ := http.Post(ts.URL+"/tasks/links", "application/json", bytes.NewReader(body))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("add %s: status %d", name, resp.StatusCode)
+		}
+		waitVersion(t, mgr, id, i+1)
+	}
+
+	resp, _ := http.Get(ts.URL + "/tasks/status/" + id)
+	var status struct {
+		Version  int                      `json:"version"`
+		Versions []map[string]interface{} `json:"versions"`
+	}
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if status.Version != 2 || len(status.Versions) != 2 {
+		t.Fatalf("unexpected versions %+v", status)
+	}
+
+	for _, c := range []struct {
+		query string
+		files int
+		name  string
+	}{
+		{"", 2, id + ".zip"},
+		{"?version=1", 1, id + "-v1.zip"},
+		{"?version=2", 2, id + ".zip"},
+	} {
+		resp, _ := http.Get(ts.URL + "/download/" + id + c.query)
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", c.query, resp.StatusCode)
+		}
+		if files := readArchive(t, FormatZip, data); len(files) != c.files {
+			t.Fatalf("%s: unexpected contents %v", c.query, files)
+		}
+		if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename="`+c.name+`"` {
+			t.Fatalf("%s: unexpected content disposition %s", c.query, cd)
+		}
+	}
+	resp, _ = http.Get(ts.URL + "/download/" + id + "?version=5")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown version, got %d", resp.StatusCode)
+	}
+	for _, query := range []string{"?version=abc", "?version=", "?version=0", "?version=-1"} {
+		resp, _ = http.Get(ts.URL + "/download/" + id + query)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnprocessableEntity || resp.Header.Get("Digest") != "" {
+			t.Fatalf("%s: expected 422, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestAddLinkLayout(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer fileSrv.Close()
+
+	ts, _ := setupTestServerLimits(5, 3)
+	defer ts.Close()
+
+	resp, _ := http.Post(ts.URL+"/tasks", "application/json", bytes.NewReader([]byte(`{"layout":"{size}"}`)))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for unknown placeholder, got %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Post(ts.URL+"/tasks", "application/json", bytes.NewReader([]byte(`{"layout":"path"}`)))
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	id := out["task_id"]
+
+	links := []map[string]string{
+		{"task_id": id, "url": fileSrv.URL + "/docs/a.txt"},
+		{"task_id": id, "url": fileSrv.URL + "/b.txt", "name": "renamed.txt"},
+		{"task_id": id, "url": fileSrv.URL + "/c.txt", "path": "../../outside"},
+	}
+	for _, l := range links {
+		body, _ := json.Marshal(l)
+		resp, _ = http.Post(ts.URL+"/tasks/links", "application/json", bytes.NewReader(body))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("add link: status %d", resp.StatusCode)
+		}
+	}
+
+	for i := 0; i < 40; i++ {
+		resp, err := http.Get(ts.URL + "/download/" + id)
+		if err != nil {
+			t.Fatalf("download: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			files := readArchive(t, FormatZip, data)
+			want := map[string]string{
+				"docs/a.txt":    "/docs/a.txt",
+				"renamed.txt":   "/b.txt",
+				"outside/c.txt": "/c.txt",
+			}
+			if len(files) != len(want) {
+				t.Fatalf("unexpected entries %v", files)
+			}
+			for name, content := range want {
+				if files[name] != content {
+					t.Fatalf("entry %s: got %q, files %v", name, files[name], files)
+				}
+			}
+			return
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatal("archive not ready")
+}
+
+func TestCreateEncryptedTask(t *testing.T) {
+	ts, _ := setupTestServer()
+	defer ts.Close()
+
+	resp, _ := http.Post(ts.URL+"/tasks", "application/json", bytes.NewReader([]byte(`{"encrypt":true}`)))
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if out["password"] == "" {
+		t.Fatal("expected generated password in response")
+	}
+
+	resp, _ = http.Post(ts.URL+"/tasks", "application/json", bytes.NewReader([]byte(`{"encrypt":true,"password":"mine"}`)))
+	out = map[string]string{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if _, ok := out["password"]; ok {
+		t.Fatal("supplied password must not be echoed")
+	}
+
+	listResp, _ := http.Get(ts.URL + "/tasks/list")
+	data, _ := io.ReadAll(listResp.Body)
+	listResp.Body.Close()
+	if bytes.Contains(data, []byte("mine")) || bytes.Contains(data, []byte("password")) {
+		t.Fatalf("password leaked in task list: %s", data)
+	}
+}
+
+func TestDownloadRecipientEncrypted(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer fileSrv.Close()
+
+	ts, _ := setupTestServerLimits(5, 1)
+	defer ts.Close()
+
+	identity, _ := age.GenerateX25519Identity()
+	body, _ := json.Marshal(map[string]interface{}{"recipients": []string{identity.Recipient().String()}})
+	resp, _ := http.Post(ts.URL+"/tasks", "application/json", bytes.NewReader(body))
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	id := out["task_id"]
+
+	body, _ = json.Marshal(map[string]string{"task_id": id, "url": fileSrv.URL + "/f.txt"})
+	resp, _ = http.Post(ts.URL+"/tasks/links", "application/json", bytes.NewReader(body))
+	resp.Body.Close()
+
+	for i := 0; i < 40; i++ {
+		resp, err := http.Get(ts.URL + "/download/" + id)
+		if err != nil {
+			t.Fatalf("download: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if ct := resp.Header.Get("Content-Type"); ct != ageContentType {
+				t.Fatalf("unexpected content type %s", ct)
+			}
+			if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename="`+id+`.zip.age"` {
+				t.Fatalf("unexpected content disposition %s", cd)
+			}
+			r, err := age.Decrypt(bytes.NewReader(data), identity)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			plain, _ := io.ReadAll(r)
+			if files := readArchive(t, FormatZip, plain); files["f.txt"] != "ok" {
+				t.Fatalf("unexpected contents %v", files)
+			}
+			return
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatal("archive not ready")
+}
+
+func TestDownloadEncryptedAtRest(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer fileSrv.Close()
+
+	ts, mgr := setupTestServerLimits(5, 1)
+	defer ts.Close()
+	mgr.SetKeyRing(testKeyRing(t, "k1"))
+
+	resp, _ := http.Post(ts.URL+"/tasks", "application/json", nil)
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	id := out["task_id"]
+
+	body, _ := json.Marshal(map[string]string{"task_id": id, "url": fileSrv.URL + "/f.txt"})
+	resp, _ = http.Post(ts.URL+"/tasks/links", "application/json", bytes.NewReader(body))
+	resp.Body.Close()
+
+	for i := 0; i < 40; i++ {
+		resp, err := http.Get(ts.URL + "/download/" + id)
+		if err != nil {
+			t.Fatalf("download: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			task, _ := mgr.Status(id)
+			raw, _ := os.ReadFile(task.ZipPath)
+			if !bytes.HasPrefix(raw, []byte(atRestMagic)) {
+				t.Fatal("archive is not encrypted on disk")
+			}
+			if files := readArchive(t, FormatZip, data); files["f.txt"] != "ok" {
+				t.Fatalf("unexpected contents %v", files)
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/download/"+id, nil)
+			req.Header.Set("Range", "bytes=0-1")
+			resp, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("range request: %v", err)
+			}
+			part, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(part, data[:2]) {
+				t.Fatalf("unexpected range response %d %q", resp.StatusCode, part)
+			}
+			return
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatal("archive not ready")
+}
+
+func TestDownloadParts(t *testing.T) {
+	payload := make([]byte, 3000)
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer fileSrv.Close()
+
+	ts, _ := setupTestServerLimits(5, 1)
+	defer ts.Close()
+
+	resp, _ := http.Post(ts.URL+"/tasks", "application/json",
+		bytes.NewReader([]byte(`{"compression":"store","max_part_size":1024}`)))
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	id := out["task_id"]
+
+	body, _ := json.Marshal(map[string]string{"task_id": id, "url": fileSrv.URL + "/f.txt"})
+	resp, _ = http.Post(ts.URL+"/tasks/links", "application/json", bytes.NewReader(body))
+	resp.Body.Close()
+
+	var status struct {
+		Status string `json:"status"`
+		Parts  []struct {
+			Part int    `json:"part"`
+			Size int64  `json:"size"`
+			URL  string `json:"url"`
+		} `json:"parts"`
+	}
+	for i := 0; i < 40 && status.Status != string(StatusComplete); i++ {
+		time.Sleep(25 * time.Millisecond)
+		st, _ := http.Get(ts.URL + "/tasks/status/" + id)
+		json.NewDecoder(st.Body).Decode(&status)
+		st.Body.Close()
+	}
+	if len(status.Parts) < 3 {
+		t.Fatalf("expected at least 3 parts, got %+v", status.Parts)
+	}
+
+	var joined bytes.Buffer
+	for _, p := range status.Parts {
+		resp, err := http.Get(ts.URL + p.URL)
+		if err != nil {
+			t.Fatalf("download part: %v", err)
+		}
+		n, _ := io.Copy(&joined, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || n != p.Size {
+			t.Fatalf("part %d: status %d, size %d", p.Part, resp.StatusCode, n)
+		}
+		if p.Part == 1 && resp.Header.Get("Content-Disposition") != `attachment; filename="`+id+`.zip.001"` {
+			t.Fatalf("unexpected part filename %s", resp.Header.Get("Content-Disposition"))
+		}
+	}
+	if files := readArchive(t, FormatZip, joined.Bytes()); len(files["f.txt"]) != len(payload) {
+		t.Fatal("joined parts do not form the archive")
+	}
+
+	resp, _ = http.Get(ts.URL + "/download/" + id + "?part=99")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing part, got %d", resp.StatusCode)
+	}
+}
+
+func TestCreateTaskWithLinks(t *testing.T) {
+	ts, mgr := setupTestServer()
+	defer ts.Close()
+
+	body := `{"format": "tar", "links": [{"url": "http://example.com/a.txt"}, {"url": "http://example.com/b.pdf"}]}`
+	resp, err := http.Post(ts.URL+"/tasks", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	var rejected struct {
+		Error struct {
+			Code    string `json:"code"`
+			Details struct {
+				Links []LinkError `json:"links"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&rejected)
+	resp.Body.Close()
+	links := rejected.Error.Details.Links
+	if resp.StatusCode != http.StatusUnprocessableEntity || rejected.Error.Code != "invalid_links" || len(links) != 1 || links[0].Index != 1 {
+		t.Fatalf("expected per-link error, got %d %+v", resp.StatusCode, rejected)
+	}
+
+	body = `{"format": "tar", "links": [{"url": "http://example.com/a.txt", "name": "x.txt"}]}`
+	resp, err = http.Post(ts.URL+"/tasks", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	task, err := mgr.Status(out["task_id"])
+	if err != nil {
+		t.Fatalf("task not stored: %v", err)
+	}
+	if task.Status != StatusPending || len(task.Links) != 1 || task.Links[0].Name != "x.txt" || task.Options.Format != FormatTar {
+		t.Fatalf("unexpected task %+v", task)
+	}
+
+	resp, _ = http.Post(ts.URL+"/tasks", "application/json", bytes.NewBufferString(`{"start": true}`))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for start without links, got %d", resp.StatusCode)
+	}
+}
+
+func TestJobEndpoints(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer fileSrv.Close()
+	ts, _ := setupTestServerLimits(1, 2)
+	defer ts.Close()
+
+	body := `{"format": "tar", "urls": ["` + fileSrv.URL + `/a.txt", "` + fileSrv.URL + `/b.txt", "` + fileSrv.URL + `/c.txt"]}`
+	resp, err := http.Post(ts.URL+"/jobs", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	var created struct {
+		JobID string `json:"job_id"`
+		Tasks int    `json:"tasks"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if created.JobID == "" || created.Tasks != 2 {
+		t.Fatalf("unexpected create response %+v", created)
+	}
+
+	var status struct {
+		Status   TaskStatus `json:"status"`
+		Progress float64    `json:"progress"`
+		Tasks    []JobTask  `json:"tasks"`
+	}
+	for i := 0; i < 100 && status.Status != StatusComplete; i++ {
+		time.Sleep(20 * time.Millisecond)
+		resp, err = http.Get(ts.URL + "/jobs/" + created.JobID)
+		if err != nil {
+			t.Fatalf("job status: %v", err)
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+	}
+	if status.Status != StatusComplete || len(status.Tasks) != 2 {
+		t.Fatalf("unexpected job status %+v", status)
+	}
+
+	resp, err = http.Get(ts.URL + "/jobs/" + created.JobID + "/download?merge=true")
+	if err != nil {
+		t.Fatalf("download merged: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != FormatTar.ContentType() {
+		t.Fatalf("unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+	if files := readArchive(t, FormatTar, data); len(files) != 3 {
+		t.Fatalf("unexpected merged contents %v", files)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	ts, mgr := setupTestServerLimits(1, 1)
+	defer ts.Close()
+
+	type envelope struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	id, _ := mgr.Create()
+	cases := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		status      int
+		code        string
+	}{
+		{"unknown task", "/tasks/links", "application/json", `{"task_id": "missing", "url": "http://example.com/a.txt"}`, http.StatusNotFound, "task_not_found"},
+		{"bad extension", "/tasks/links", "application/json", `{"task_id": "` + id + `", "url": "http://example.com/a.exe"}`, http.StatusUnprocessableEntity, "validation_failed"},
+		{"malformed json", "/tasks/links", "application/json", `{"task_id": `, http.StatusBadRequest, "invalid_json"},
+		{"wrong type", "/tasks", "application/json", `{"format": 5}`, http.StatusBadRequest, "invalid_json"},
+		{"trailing data", "/tasks", "application/json", `{"links": []}xyz`, http.StatusBadRequest, "invalid_json"},
+		{"second value", "/tasks", "application/json", `{} {}`, http.StatusBadRequest, "invalid_json"},
+		{"not json", "/tasks", "text/plain", `format=zip`, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"too large", "/zip", "application/json", `{"urls": ["` + strings.Repeat("a", maxBodySize) + `"]}`, http.StatusRequestEntityTooLarge, "body_too_large"},
+		{"no files", "/tasks/zip", "application/json", `{"task_id": "` + id + `"}`, http.StatusUnprocessableEntity, "no_files"},
+		{"server busy", "/tasks", "application/json", `{}`, http.StatusTooManyRequests, "server_busy"},
+	}
+	for _, c := range cases {
+		if c.code == "server_busy" {
+			mgr.mu.Lock()
+			mgr.inProcess = 1
+			mgr.mu.Unlock()
+		}
+		resp, err := http.Post(ts.URL+c.path, c.contentType, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var out envelope
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || out.Error.Code != c.code || out.Error.Message == "" {
+			t.Fatalf("%s: expected %d %s, got %d %+v", c.name, c.status, c.code, resp.StatusCode, out)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("%s: unexpected content type %s", c.name, ct)
+		}
+	}
+	mgr.mu.Lock()
+	mgr.inProcess = 0
+	mgr.mu.Unlock()
+}
+
+func TestAPIv1Routes(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer fileSrv.Close()
+	ts, _ := setupTestServerLimits(5, 3)
+	defer ts.Close()
+	v1 := ts.URL + APIPrefix
+
+	resp, err := http.Post(v1+"/tasks", "application/json", nil)
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	var out map[string]string
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	id := out["task_id"]
+	if resp.Header.Get("Deprecation") != "" {
+		t.Fatal("v1 route must not be deprecated")
+	}
+
+	body := `{"url": "` + fileSrv.URL + `/a.txt"}`
+	resp, _ = http.Post(v1+"/tasks/"+id+"/links", "application/json", strings.NewReader(body))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("add link: %d", resp.StatusCode)
+	}
+	resp, _ = http.Post(v1+"/tasks/"+id+"/archive", "application/json", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("start archive: %d", resp.StatusCode)
+	}
+
+	var status struct {
+		Status     TaskStatus `json:"status"`
+		ArchiveURL string     `json:"archive_url"`
+	}
+	for i := 0; i < 50 && status.Status != StatusComplete; i++ {
+		time.Sleep(20 * time.Millisecond)
+		resp, _ = http.Get(v1 + "/tasks/" + id)
+		json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+	}
+	if status.ArchiveURL != APIPrefix+"/tasks/"+id+"/archive" {
+		t.Fatalf("unexpected archive url %q", status.ArchiveURL)
+	}
+	resp, _ = http.Get(ts.URL + status.ArchiveURL)
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if files := readArchive(t, FormatZip, data); files["a.txt"] != "ok" {
+		t.Fatalf("unexpected archive contents %v", files)
+	}
+
+	resp, _ = http.Get(ts.URL + "/tasks/status/" + id)
+	resp.Body.Close()
+	if resp.Header.Get("Deprecation") != "@1792281600" || resp.Header.Get("Sunset") != "Sun, 18 Apr 2027 00:00:00 GMT" ||
+		resp.Header.Get("Link") != "<"+APIPrefix+"/tasks/"+id+`>; rel="successor-version", </docs>; rel="deprecation"` {
+		t.Fatalf("unexpected deprecation headers %v", resp.Header)
+	}
+	resp, _ = http.Get(ts.URL + "/download/" + id)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Link") != "<"+APIPrefix+"/tasks/"+id+`/archive>; rel="successor-version", </docs>; rel="deprecation"` {
+		t.Fatalf("unexpected legacy download response %d %v", resp.StatusCode, resp.Header)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, v1+"/tasks/"+id, nil)
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete: %d", resp.StatusCode)
+	}
+	resp, _ = http.Get(v1 + "/tasks/" + id)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", resp.StatusCode)
+	}
+}
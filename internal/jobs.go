@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// jobPollInterval — как часто задание проверяет, освободился ли слот обработки
+var jobPollInterval = 100 * time.Millisecond
+
+var jobCounter uint64
+
+// Job — задание на большой список ссылок. Ссылки делятся на задачи по
+// maxFilesPerTask, которые запускаются через обычный менеджер по мере
+// освобождения слотов обработки
+type Job struct {
+	ID        string
+	Options   TaskOptions
+	Links     []Link
+	TaskIDs   []string // созданные задачи в порядке частей списка
+	Failure   string   // причина, по которой оставшиеся части не были запущены
+	chunks    int
+	createdAt time.Time
+}
+
+// JobTask — состояние одной задачи задания
+type JobTask struct {
+	TaskID   string     `json:"task_id"`
+	Status   TaskStatus `json:"status"`
+	Links    int        `json:"links"`
+	Progress float64    `json:"progress"`
+}
+
+// CreateJob проверяет все ссылки и создаёт задание, возвращая его ID и число
+// задач, на которые разбит список. Шифрование паролем не поддерживается:
+// пароль пришлось бы хранить до запуска последней задачи
+func (m *TaskManager) CreateJob(opts TaskOptions, links []Link) (string, int, []LinkError, error) {
+	if len(links) == 0 {
+		err := ErrNoFiles
+		Logger.WithError(err).Error("create job failed")
+		return "", 0, nil, err
+	}
+	if opts.Encrypt {
+		err := invalidf("encryption is not supported for jobs")
+		Logger.WithError(err).Error("create job failed")
+		return "", 0, nil, err
+	}
+	if err := m.ValidateOptions(opts); err != nil {
+		Logger.WithError(err).Error("create job failed")
+		return "", 0, nil, err
+	}
+	if errs := m.checkLinks(links, 0); len(errs) > 0 {
+		err := ErrInvalidLinks
+		Logger.WithError(err).WithField("rejected", len(errs)).Error("create job failed")
+		return "", 0, errs, err
+	}
+	m.mu.Lock()
+	job := &Job{
+		ID:        fmt.Sprintf("job-%d", atomic.AddUint64(&jobCounter, 1)),
+		Options:   opts.withDefaults(m.defaults),
+		Links:     links,
+		chunks:    (len(links) + m.maxFiles - 1) / m.maxFiles,
+		createdAt: time.Now(),
+	}
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+
+	Logger.WithFields(logrus.Fields{"job_id": job.ID, "links": len(links), "tasks": job.chunks}).Info("job created")
+	go m.runJob(job)
+	return job.ID, job.chunks, nil, nil
+}
+
+// runJob создаёт и запускает задачи задания, дожидаясь свободного слота
+// обработки перед каждой следующей частью
+func (m *TaskManager) runJob(job *Job) {
+	defer func() {
+		// задачи могли удалить, пока запускались остальные части
+		m.mu.Lock()
+		m.pruneJob(job)
+		m.mu.Unlock()
+	}()
+	for start := 0; start < len(job.Links); start += m.maxFiles {
+		end := start + m.maxFiles
+		if end > len(job.Links) {
+			end = len(job.Links)
+		}
+		for {
+			m.mu.Lock()
+			busy := m.inProcess >= m.maxTasks
+			m.mu.Unlock()
+			if busy {
+				time.Sleep(jobPollInterval)
+				continue
+			}
+			id, _, err := m.CreateBatch(job.Options, job.Links[start:end], true)
+			if errors.Is(err, ErrServerBusy) {
+				continue
+			}
+			m.mu.Lock()
+			if err != nil {
+				job.Failure = err.Error()
+			} else {
+				job.TaskIDs = append(job.TaskIDs, id)
+			}
+			m.mu.Unlock()
+			if err != nil {
+				Logger.WithError(err).WithField("job_id", job.ID).Error("job stopped")
+				return
+			}
+			break
+		}
+	}
+	Logger.WithField("job_id", job.ID).Info("all job tasks started")
+}
+
+// pruneJob удаляет задание, когда все его части запущены (или запуск
+// прерван), а все созданные задачи удалены. Вызывается под m.mu
+func (m *TaskManager) pruneJob(job *Job) {
+	if len(job.TaskIDs) == 0 || (len(job.TaskIDs) < job.chunks && job.Failure == "") {
+		return
+	}
+	for _, id := range job.TaskIDs {
+		if _, ok := m.tasks[id]; ok {
+			return
+		}
+		if _, ok := m.completed[id]; ok {
+			return
+		}
+	}
+	delete(m.jobs, job.ID)
+	Logger.WithField("job_id", job.ID).Info("job removed")
+}
+
+// pruneJobs удаляет задания, к которым относилась удалённая задача, если
+// у них не осталось задач. Вызывается под m.mu
+func (m *TaskManager) pruneJobs(taskID string) {
+	for _, job := range m.jobs {
+		for _, id := range job.TaskIDs {
+			if id == taskID {
+				m.pruneJob(job)
+				break
+			}
+		}
+	}
+}
+
+func (m *TaskManager) Job(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// JobProgress возвращает состояние задач задания, общий статус, процент
+// выполнения и причину остановки; ещё не запущенные части считаются с
+// нулевым прогрессом
+func (m *TaskManager) JobProgress(job *Job) ([]JobTask, TaskStatus, float64, string) {
+	m.mu.Lock()
+	ids := append([]string(nil), job.TaskIDs...)
+	failure := job.Failure
+	m.mu.Unlock()
+
+	tasks := make([]JobTask, 0, len(ids))
+	var sum float64
+	complete, active := 0, 0
+	for _, id := range ids {
+		task, err := m.Status(id)
+		if err != nil {
+			tasks = append(tasks, JobTask{TaskID: id, Status: StatusFailed})
+			continue
+		}
+		_, progress := m.Progress(task)
+		jt := JobTask{TaskID: id, Status: task.Status, Links: len(task.Urls), Progress: progress}
+		switch jt.Status {
+		case StatusComplete:
+			complete++
+		case StatusPending, StatusProcessing:
+			active++
+		}
+		sum += progress
+		tasks = append(tasks, jt)
+	}
+
+	var status TaskStatus
+	switch {
+	case failure != "":
+		status = StatusFailed
+	case len(ids) == 0:
+		status = StatusPending
+	case len(ids) < job.chunks || active > 0:
+		status = StatusProcessing
+	case complete == job.chunks:
+		status = StatusComplete
+	default:
+		status = StatusFailed
+	}
+	return tasks, status, sum / float64(job.chunks), failure
+}
+
+// JobArchives возвращает задачи готового задания для скачивания. Для
+// объединённого архива (merge) содержимое задач должно читаться сервером,
+// поэтому задания с шифрованием для получателей не подходят
+func (m *TaskManager) JobArchives(id string, merge bool) (*Job, []*Task, error) {
+	job, err := m.Job(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, status, _, _ := m.JobProgress(job); status != StatusComplete {
+		return nil, nil, fmt.Errorf("%w: job not complete", ErrNotReady)
+	}
+	if merge && len(job.Options.Recipients) > 0 {
+		return nil, nil, invalidf("encrypted job cannot be merged")
+	}
+	m.mu.Lock()
+	ids := append([]string(nil), job.TaskIDs...)
+	m.mu.Unlock()
+	tasks := make([]*Task, 0, len(ids))
+	for _, id := range ids {
+		task, err := m.Status(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return job, tasks, nil
+}
+
+// WriteJobBundle пишет в w zip без сжатия, в котором лежат архивы всех задач
+func (m *TaskManager) WriteJobBundle(w io.Writer, tasks []*Task) error {
+	zw := zip.NewWriter(w)
+	for _, task := range tasks {
+		v, err := m.Version(task, 0)
+		if err != nil {
+			return err
+		}
+		archive, err := m.openVersion(v)
+		if err != nil {
+			return err
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     task.ID + task.Options.ArchiveExt(),
+			Method:   zip.Store,
+			Modified: time.Now(),
+		})
+		if err == nil {
+			_, err = io.Copy(fw, archive)
+		}
+		archive.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// WriteJobMerged пишет в w один архив со всеми файлами задач задания.
+// Совпадающие имена из разных задач получают суффикс -2, -3 и т.д.
+func (m *TaskManager) WriteJobMerged(w io.Writer, job *Job, tasks []*Task) error {
+	aw, err := newArchiveWriter(w, job.Options, m.storeExts, "")
+	if err != nil {
+		return err
+	}
+	used := make(map[string]struct{})
+	for _, task := range tasks {
+		prev, err := m.openPrevious(task)
+		if err != nil {
+			return err
+		}
+		err = prev.copyAll(aw, used)
+		prev.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", task.ID, err)
+		}
+	}
+	return aw.Close()
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func waitJob(t *testing.T, mgr *TaskManager, id string) *Job {
+	t.Helper()
+	job, err := mgr.Job(id)
+	if err != nil {
+		t.Fatalf("job: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, status, _, _ := mgr.JobProgress(job); status == StatusComplete {
+			return job
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("job not completed")
+	return nil
+}
+
+func TestJobSplitsLinks(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer fileSrv.Close()
+
+	mgr := NewManager(1, 2, []string{".txt"})
+	var links []Link
+	for _, p := range []string{"/a/x.txt", "/b/x.txt", "/c.txt", "/d.txt", "/e.txt"} {
+		links = append(links, Link{URL: fileSrv.URL + p})
+	}
+	id, chunks, errs, err := mgr.CreateJob(TaskOptions{}, links)
+	if err != nil || chunks != 3 {
+		t.Fatalf("create job: %v %+v, %d tasks", err, errs, chunks)
+	}
+	job := waitJob(t, mgr, id)
+	tasks, _, progress, _ := mgr.JobProgress(job)
+	if len(tasks) != 3 || tasks[0].Links != 2 || tasks[2].Links != 1 || progress != 100 {
+		t.Fatalf("unexpected job tasks %+v, progress %v", tasks, progress)
+	}
+
+	_, archives, err := mgr.JobArchives(id, true)
+	if err != nil {
+		t.Fatalf("job archives: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := mgr.WriteJobMerged(&buf, job, archives); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	files := readArchive(t, FormatZip, buf.Bytes())
+	if len(files) != 5 || files["x.txt"] != "/a/x.txt" || files["x-2.txt"] != "/b/x.txt" || files["e.txt"] != "/e.txt" {
+		t.Fatalf("unexpected merged contents %v", files)
+	}
+
+	buf.Reset()
+	if err := mgr.WriteJobBundle(&buf, archives); err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("open bundle: %v", err)
+	}
+	if len(zr.File) != 3 || zr.File[0].Name != tasks[0].TaskID+".zip" {
+		t.Fatalf("unexpected bundle entries %v", zr.File)
+	}
+}
+
+func TestJobValidation(t *testing.T) {
+	mgr := NewManager(1, 2, []string{".txt"})
+	links := []Link{{URL: "http://example.com/a.txt"}, {URL: "http://example.com/b.exe"}, {URL: "http://example.com/a.txt"}}
+	_, _, errs, err := mgr.CreateJob(TaskOptions{}, links)
+	if err == nil || len(errs) != 2 || errs[0].Index != 1 || errs[1].Index != 2 {
+		t.Fatalf("expected per-link errors, got %v %+v", err, errs)
+	}
+	if _, _, _, err := mgr.CreateJob(TaskOptions{Encrypt: true, Password: "secret"}, links[:1]); err == nil {
+		t.Fatal("expected error for encrypted job")
+	}
+	if _, _, _, err := mgr.CreateJob(TaskOptions{}, nil); err == nil {
+		t.Fatal("expected error for empty job")
+	}
+}
+
+func TestJobRemovedWithTasks(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer fileSrv.Close()
+
+	mgr := NewManager(2, 2, []string{".txt"})
+	links := []Link{{URL: fileSrv.URL + "/a.txt"}, {URL: fileSrv.URL + "/b.txt"}, {URL: fileSrv.URL + "/c.txt"}}
+	id, _, _, err := mgr.CreateJob(TaskOptions{}, links)
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	job := waitJob(t, mgr, id)
+	tasks, _, _, _ := mgr.JobProgress(job)
+	mgr.Delete(tasks[0].TaskID)
+	if _, err := mgr.Job(id); err != nil {
+		t.Fatalf("job removed while a task remains: %v", err)
+	}
+	mgr.Delete(tasks[1].TaskID)
+	if _, err := mgr.Job(id); err != ErrJobNotFound {
+		t.Fatalf("expected job removed with its last task, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"fmt"
+	neturl "net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Режимы раскладки файлов в архиве; любое другое значение с фигурными
+// скобками считается шаблоном, например "{host}/{index}_{name}"
+const (
+	LayoutFlat = "flat"
+	LayoutHost = "host"
+	LayoutPath = "path"
+)
+
+var placeholderRe = regexp.MustCompile(`\{[^{}]*\}`)
+
+var layoutPlaceholders = map[string]struct{}{
+	"{host}":  {},
+	"{index}": {},
+	"{name}":  {},
+	"{path}":  {},
+	"{ext}":   {},
+}
+
+// Link — ссылка задачи с необязательными именем файла и каталогом в архиве.
+// Extract распаковывает скачанный zip или tar.gz в каталог с именем файла
+type Link struct {
+	URL     string `json:"url"`
+	Name    string `json:"name,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Extract bool   `json:"extract,omitempty"`
+}
+
+func validateLayout(layout string) error {
+	switch layout {
+	case "", LayoutFlat, LayoutHost, LayoutPath:
+		return nil
+	}
+	if !strings.Contains(layout, "{") {
+		return invalidf("unsupported layout %s", layout)
+	}
+	for _, ph := range placeholderRe.FindAllString(layout, -1) {
+		if _, ok := layoutPlaceholders[ph]; !ok {
+			return invalidf("unknown layout placeholder %s", ph)
+		}
+	}
+	return nil
+}
+
+// sanitizePath приводит путь к безопасному относительному виду: убирает
+// пустые сегменты, "." и "..", обратные слэши, двоеточия и управляющие символы
+func sanitizePath(p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+	var parts []string
+	for _, seg := range strings.Split(p, "/") {
+		seg = strings.Map(func(r rune) rune {
+			if r < 0x20 || r == 0x7f || r == ':' {
+				return -1
+			}
+			return r
+		}, seg)
+		seg = strings.TrimSpace(seg)
+		if seg == "" || seg == "." || seg == ".." {
+			continue
+		}
+		parts = append(parts, seg)
+	}
+	return strings.Join(parts, "/")
+}
+
+// entryName вычисляет путь файла в архиве для ссылки с номером index (с нуля)
+func entryName(link Link, index int, layout string) string {
+	parsed, err := neturl.Parse(link.URL)
+	if err != nil {
+		parsed = &neturl.URL{}
+	}
+	name := link.Name
+	if name == "" {
+		name = path.Base(parsed.Path)
+	}
+	name = sanitizePath(path.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "" {
+		name = fmt.Sprintf("file-%d", index+1)
+	}
+	urlDir := sanitizePath(path.Dir(parsed.Path))
+
+	var full string
+	switch {
+	case link.Path != "":
+		full = link.Path + "/" + name
+	case layout == "" || layout == LayoutFlat:
+		full = name
+	case layout == LayoutHost:
+		full = parsed.Hostname() + "/" + name
+	case layout == LayoutPath:
+		full = urlDir + "/" + name
+	default:
+		full = placeholderRe.ReplaceAllStringFunc(layout, func(ph string) string {
+			switch ph {
+			case "{host}":
+				return parsed.Hostname()
+			case "{index}":
+				return strconv.Itoa(index + 1)
+			case "{name}":
+				return name
+			case "{path}":
+				return urlDir
+			case "{ext}":
+				return strings.TrimPrefix(path.Ext(name), ".")
+			}
+			return ""
+		})
+	}
+	if full = sanitizePath(full); full == "" {
+		full = fmt.Sprintf("file-%d", index+1)
+	}
+	return full
+}
+
+// entryNames вычисляет пути всех файлов задачи, добавляя к совпадающим
+// именам суффикс -2, -3 и т.д. Непустые fixed[i] — имена файлов из
+// предыдущей версии архива, они сохраняются без изменений
+func entryNames(links []Link, layout string, fixed []string) []string {
+	names := make([]string, len(links))
+	used := make(map[string]struct{}, len(links))
+	for i := range fixed {
+		if fixed[i] != "" {
+			used[fixed[i]] = struct{}{}
+		}
+	}
+	for i, link := range links {
+		if i < len(fixed) && fixed[i] != "" {
+			names[i] = fixed[i]
+			continue
+		}
+		names[i] = uniqueName(used, entryName(link, i, layout))
+	}
+	return names
+}
+
+// uniqueName добавляет к имени, уже занятому в used, суффикс -2, -3 и т.д.
+// и отмечает результат как занятый
+func uniqueName(used map[string]struct{}, name string) string {
+	if _, dup := used[name]; dup {
+		ext := path.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for n := 2; ; n++ {
+			candidate := fmt.Sprintf("%s-%d%s", base, n, ext)
+			if _, dup := used[candidate]; !dup {
+				name = candidate
+				break
+			}
+		}
+	}
+	used[name] = struct{}{}
+	return name
+}
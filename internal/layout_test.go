@@ -0,0 +1,76 @@
+package internal
+
+import "testing"
+
+func TestValidateLayout(t *testing.T) {
+	for _, ok := range []string{"", "flat", "host", "path", "{host}/{index}_{name}", "docs/{ext}/{name}"} {
+		if err := validateLayout(ok); err != nil {
+			t.Errorf("layout %q: %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"tree", "{host}/{size}"} {
+		if err := validateLayout(bad); err == nil {
+			t.Errorf("layout %q: expected error", bad)
+		}
+	}
+}
+
+func TestEntryNameLayouts(t *testing.T) {
+	link := Link{URL: "https://cdn.example.com/img/2024/cat.jpg?token=abc"}
+	cases := map[string]string{
+		"":                      "cat.jpg",
+		LayoutFlat:              "cat.jpg",
+		LayoutHost:              "cdn.example.com/cat.jpg",
+		LayoutPath:              "img/2024/cat.jpg",
+		"{host}/{index}_{name}": "cdn.example.com/3_cat.jpg",
+		"by-type/{ext}/{name}":  "by-type/jpg/cat.jpg",
+	}
+	for layout, want := range cases {
+		if got := entryName(link, 2, layout); got != want {
+			t.Errorf("layout %q: got %q, want %q", layout, got, want)
+		}
+	}
+
+	link.Name = "photo.jpg"
+	link.Path = "pets/cats"
+	if got := entryName(link, 0, LayoutHost); got != "pets/cats/photo.jpg" {
+		t.Errorf("explicit name and path: got %q", got)
+	}
+}
+
+func TestEntryNameSanitized(t *testing.T) {
+	cases := []struct {
+		link Link
+		want string
+	}{
+		{Link{URL: "http://h/a.txt", Path: "../../etc"}, "etc/a.txt"},
+		{Link{URL: "http://h/a.txt", Path: "/abs/./dir/"}, "abs/dir/a.txt"},
+		{Link{URL: "http://h/a.txt", Path: `..\..\win`}, "win/a.txt"},
+		{Link{URL: "http://h/a.txt", Name: "../../passwd"}, "passwd"},
+		{Link{URL: "http://h/a.txt", Name: `..\evil.txt`}, "evil.txt"},
+		{Link{URL: "http://h/a.txt", Name: "C:boot.ini"}, "Cboot.ini"},
+		{Link{URL: "http://h/a.txt", Name: ".."}, "file-1"},
+		{Link{URL: "http://h/"}, "file-1"},
+	}
+	for _, c := range cases {
+		if got := entryName(c.link, 0, ""); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.link, got, c.want)
+		}
+	}
+}
+
+func TestEntryNamesDeduplicated(t *testing.T) {
+	links := []Link{
+		{URL: "http://a.com/x/f.txt"},
+		{URL: "http://b.com/y/f.txt"},
+		{URL: "http://c.com/f.txt"},
+		{URL: "http://d.com/f-2.txt"},
+	}
+	got := entryNames(links, LayoutFlat, nil)
+	want := []string{"f.txt", "f-2.txt", "f-3.txt", "f-2-2.txt"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// editableLink находит ссылку ожидающей задачи по ссылке ref: номеру в списке
+// urls (с нуля) или самому URL. Ссылки, уже попавшие в собранную версию
+// архива, не меняются. Вызывается под m.mu
+func (m *TaskManager) editableLink(id, ref string) (*Task, int, error) {
+	task, ok := m.tasks[id]
+	if !ok {
+		if _, done := m.completed[id]; done {
+			return nil, 0, ErrTaskCompleted
+		}
+		return nil, 0, ErrTaskNotFound
+	}
+	if task.Status != StatusPending {
+		return nil, 0, ErrTaskProcessing
+	}
+	i := -1
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n >= 0 && n < len(task.Urls) {
+			i = n
+		}
+	} else {
+		for k, u := range task.Urls {
+			if u == ref {
+				i = k
+				break
+			}
+		}
+	}
+	if i < 0 {
+		return nil, 0, ErrLinkNotFound
+	}
+	if i < len(task.entries) {
+		return nil, 0, ErrLinkArchived
+	}
+	return task, i, nil
+}
+
+// RemoveLink убирает ссылку из ожидающей задачи; следующие ссылки сдвигаются.
+// Освободившееся место снова доступно в пределах maxFilesPerTask. Если у
+// дополняемой задачи не осталось новых ссылок, она возвращается к текущей версии
+func (m *TaskManager) RemoveLink(id, ref string) error {
+	m.mu.Lock()
+	task, i, err := m.editableLink(id, ref)
+	if err != nil {
+		m.mu.Unlock()
+		Logger.WithError(err).WithFields(logrus.Fields{"task_id": id, "link": ref}).Error("remove link failed")
+		return err
+	}
+	url := task.Urls[i]
+	task.Urls = append(task.Urls[:i], task.Urls[i+1:]...)
+	task.Links = append(task.Links[:i], task.Links[i+1:]...)
+	task.Files = append(task.Files[:i], task.Files[i+1:]...)
+	delete(task.Errors, url)
+	reverted := task.Version > 0 && len(task.Urls) == len(task.entries)
+	if reverted {
+		delete(m.tasks, id)
+		m.completed[id] = task
+		m.revert(task)
+	}
+	m.publishTask(task)
+	m.mu.Unlock()
+
+	Logger.WithFields(logrus.Fields{"task_id": id, "url": url}).Info("link removed")
+	if reverted {
+		Logger.WithFields(logrus.Fields{"task_id": id, "version": task.Version}).Info("task reopen reverted")
+	}
+	return nil
+}
+
+// ReplaceLink заменяет ссылку ожидающей задачи на link, сохраняя её место
+// в списке. Число ссылок не меняется, поэтому сборка не запускается
+func (m *TaskManager) ReplaceLink(id, ref string, link Link) error {
+	m.mu.Lock()
+	task, i, err := m.editableLink(id, ref)
+	if err == nil {
+		others := append(append([]string{}, task.Urls[:i]...), task.Urls[i+1:]...)
+		err = m.checkURL(others, link.URL)
+	}
+	if err != nil {
+		m.mu.Unlock()
+		Logger.WithError(err).WithFields(logrus.Fields{"task_id": id, "link": ref}).Error("replace link failed")
+		return err
+	}
+	old := task.Urls[i]
+	task.Urls[i] = link.URL
+	task.Links[i] = link
+	task.Files[i] = newFileProgress(link.URL)
+	delete(task.Errors, old)
+	m.publishTask(task)
+	m.mu.Unlock()
+
+	Logger.WithFields(logrus.Fields{"task_id": id, "old_url": old, "url": link.URL}).Info("link replaced")
+	return nil
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRemoveAndReplaceLink(t *testing.T) {
+	srv, hits := countingServer(t)
+	mgr := NewManager(2, 3, []string{".txt"})
+	id, _ := mgr.Create()
+	mgr.AddURL(id, srv.URL+"/a.txt")
+	mgr.AddURL(id, srv.URL+"/b.txt")
+
+	if err := mgr.RemoveLink(id, "0"); err != nil {
+		t.Fatalf("remove by index: %v", err)
+	}
+	if err := mgr.ReplaceLink(id, srv.URL+"/b.txt", Link{URL: srv.URL + "/c.txt", Name: "renamed.txt"}); err != nil {
+		t.Fatalf("replace by url: %v", err)
+	}
+	if err := mgr.ReplaceLink(id, "0", Link{URL: srv.URL + "/c.pdf"}); err == nil {
+		t.Fatal("expected extension error on replace")
+	}
+	if err := mgr.RemoveLink(id, "5"); !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("expected link not found, got %v", err)
+	}
+
+	// после удаления лимит считается заново: третья ссылка ещё не запускает сборку
+	mgr.AddURL(id, srv.URL+"/a.txt")
+	task, _ := mgr.Status(id)
+	if urls := task.Urls; task.Status != StatusPending || len(urls) != 2 || urls[0] != srv.URL+"/c.txt" || urls[1] != srv.URL+"/a.txt" {
+		t.Fatalf("unexpected task after edits: %s %v", task.Status, urls)
+	}
+	mgr.AddURL(id, srv.URL+"/d.txt")
+	task = waitVersion(t, mgr, id, 1)
+
+	files := readVersion(t, mgr, task, FormatZip, 1)
+	if len(files) != 3 || files["renamed.txt"] != "/c.txt" {
+		t.Fatalf("unexpected archive contents %v", files)
+	}
+	if hits("/b.txt") != 0 {
+		t.Fatal("replaced link was downloaded")
+	}
+	if err := mgr.RemoveLink(id, "0"); !errors.Is(err, ErrTaskCompleted) {
+		t.Fatalf("expected completed error, got %v", err)
+	}
+
+	// после повторного открытия менять можно только новые ссылки
+	mgr.AddURL(id, srv.URL+"/e.txt")
+	if err := mgr.RemoveLink(id, "0"); !errors.Is(err, ErrLinkArchived) {
+		t.Fatalf("expected archived error, got %v", err)
+	}
+	if err := mgr.RemoveLink(id, "3"); err != nil {
+		t.Fatalf("remove new link: %v", err)
+	}
+	// без новых ссылок задача снова завершена с прежней версией архива
+	task, _ = mgr.Status(id)
+	if task.Status != StatusComplete || task.Version != 1 || len(task.Urls) != 3 {
+		t.Fatalf("expected version 1 restored, got %s v%d %v", task.Status, task.Version, task.Urls)
+	}
+	if files := readVersion(t, mgr, task, FormatZip, 0); len(files) != 3 {
+		t.Fatalf("current version not available: %v", files)
+	}
+	mgr.Delete(id)
+}
+
+func TestLinkEndpoints(t *testing.T) {
+	ts, mgr := setupTestServerLimits(5, 3)
+	defer ts.Close()
+
+	resp, _ := http.Post(ts.URL+"/api/v1/tasks", "application/json", strings.NewReader(
+		`{"links": [{"url": "http://example.com/a.txt"}, {"url": "http://example.com/b.txt"}]}`))
+	var created map[string]string
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	id := created["task_id"]
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/tasks/"+id+"/links/"+url.PathEscape("http://example.com/a.txt"), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("remove link: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 on remove, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/tasks/"+id+"/links/0", strings.NewReader(`{"url": "http://example.com/c.txt"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("replace link: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 on replace, got %d", resp.StatusCode)
+	}
+	task, _ := mgr.Status(id)
+	if len(task.Urls) != 1 || task.Urls[0] != "http://example.com/c.txt" {
+		t.Fatalf("unexpected urls %v", task.Urls)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/tasks/"+id+"/links/1", nil)
+	resp, _ = http.DefaultClient.Do(req)
+	var out struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || out.Error.Code != "link_not_found" {
+		t.Fatalf("expected link_not_found, got %d %q", resp.StatusCode, out.Error.Code)
+	}
+}
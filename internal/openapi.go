@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/sirupsen/logrus"
+)
+
+// openAPISpec — описание API в формате OpenAPI 3, встроенное в бинарник
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// docsPage — страница документации, которая строится по /openapi.json
+//
+//go:embed docs.html
+var docsPage []byte
+
+// LoadSpec разбирает и проверяет встроенное описание API
+func LoadSpec() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec: %v", err)
+	}
+	return doc, nil
+}
+
+// OpenAPI отдаёт описание API
+func (api *API) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// Docs отдаёт страницу документации API
+func (api *API) Docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// ValidateRequests возвращает middleware, которое проверяет параметры и тело
+// запроса по описанию API до вызова обработчика. Запросы к маршрутам, которых
+// нет в описании (устаревшие псевдонимы, статика), пропускаются без проверки
+func ValidateRequests(doc *openapi3.T) (func(http.Handler) http.Handler, error) {
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	opts := &openapi3filter.Options{
+		AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+		SkipSettingDefaults: true,
+	}
+	opts.WithCustomSchemaErrorFunc(func(err *openapi3.SchemaError) string {
+		if ptr := err.JSONPointer(); len(ptr) > 0 {
+			return strings.Join(ptr, ".") + ": " + err.Reason
+		}
+		return err.Reason
+	})
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, params, err := router.FindRoute(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if isJSON(r) {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+			}
+			err = openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: params,
+				Route:      route,
+				Options:    opts,
+			})
+			if err != nil {
+				err = requestError(err)
+				Logger.WithError(err).WithFields(logrus.Fields{"method": r.Method, "path": r.URL.Path}).Error("request rejected by spec")
+				writeError(w, err, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// requestError переводит ошибку проверки запроса в ошибки сервиса: чужой
+// Content-Type — 415, слишком большое тело — 413, остальное — 422
+func requestError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return tooLarge
+	}
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) && reqErr.RequestBody != nil && strings.HasPrefix(reqErr.Reason, "header Content-Type") {
+		return errUnsupportedMedia
+	}
+	return invalidf("%s", err.Error())
+}
+
+// isJSON сообщает, заявлено ли тело запроса как JSON
+func isJSON(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == "application/json"
+}
@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/go-chi/chi/v5"
+)
+
+func loadTestSpec(t *testing.T) *openapi3.T {
+	t.Helper()
+	doc, err := LoadSpec()
+	if err != nil {
+		t.Fatalf("load spec: %v", err)
+	}
+	return doc
+}
+
+// TestSpecMatchesRoutes проверяет, что каждый маршрут /api/v1 описан в
+// спецификации и каждая операция спецификации обслуживается роутером
+func TestSpecMatchesRoutes(t *testing.T) {
+	doc := loadTestSpec(t)
+	r := chi.NewRouter()
+	(&API{Manager: NewManager(1, 1, nil)}).Mount(r)
+
+	var routes []string
+	chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, APIPrefix+"/") || strings.HasPrefix(route, "/.well-known/") {
+			routes = append(routes, method+" "+route)
+		}
+		return nil
+	})
+	var specOps []string
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			specOps = append(specOps, method+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	sort.Strings(specOps)
+	if strings.Join(routes, "\n") != strings.Join(specOps, "\n") {
+		t.Fatalf("routes and spec differ:\nrouter:\n%s\n\nspec:\n%s", strings.Join(routes, "\n"), strings.Join(specOps, "\n"))
+	}
+}
+
+// specRecorder прогоняет запросы через роутер и сверяет ответы со спецификацией
+func specRecorder(t *testing.T, doc *openapi3.T, next http.Handler) http.Handler {
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		t.Fatalf("spec router: %v", err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+
+		route, params, err := router.FindRoute(r)
+		if err != nil {
+			t.Errorf("%s %s: not in spec: %v", r.Method, r.URL.Path, err)
+			return
+		}
+		ct := rec.Header().Get("Content-Type")
+		mt, _, _ := mime.ParseMediaType(ct)
+		resp := route.Operation.Responses.Status(rec.Code)
+		if resp == nil {
+			resp = route.Operation.Responses.Default()
+		}
+		if resp == nil || resp.Value.Content.Get(ct) == nil {
+			t.Errorf("%s %s: response %d %s not in spec", r.Method, r.URL.Path, rec.Code, ct)
+			return
+		}
+		err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: &openapi3filter.RequestValidationInput{Request: r, PathParams: params, Route: route},
+			Status:                 rec.Code,
+			Header:                 rec.Header(),
+			Body:                   io.NopCloser(bytes.NewReader(rec.Body.Bytes())),
+			Options:                &openapi3filter.Options{ExcludeResponseBody: mt != "application/json"},
+		})
+		if err != nil {
+			t.Errorf("%s %s: response does not match spec: %v", r.Method, r.URL.Path, err)
+		}
+	})
+}
+
+func TestSpecResponses(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer fileSrv.Close()
+	doc := loadTestSpec(t)
+	r := chi.NewRouter()
+	(&API{Manager: NewManager(2, 2, []string{".txt"})}).Mount(r)
+	ts := httptest.NewServer(specRecorder(t, doc, r))
+	defer ts.Close()
+
+	call := func(method, path, body string) []byte {
+		t.Helper()
+		var rd io.Reader
+		if body != "" {
+			rd = strings.NewReader(body)
+		}
+		req, _ := http.NewRequest(method, ts.URL+path, rd)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return data
+	}
+
+	var created struct {
+		TaskID string `json:"task_id"`
+	}
+	json.Unmarshal(call("POST", "/api/v1/tasks", `{"format": "tar", "dedup": "skip"}`), &created)
+	call("POST", "/api/v1/tasks/"+created.TaskID+"/links", `{"url": "`+fileSrv.URL+`/a.txt", "name": "a.txt"}`)
+	call("GET", "/api/v1/tasks", "")
+	call("POST", "/api/v1/tasks/"+created.TaskID+"/archive", "")
+	var status struct {
+		Status TaskStatus `json:"status"`
+	}
+	for i := 0; i < 100 && status.Status != StatusComplete; i++ {
+		time.Sleep(20 * time.Millisecond)
+		json.Unmarshal(call("GET", "/api/v1/tasks/"+created.TaskID, ""), &status)
+	}
+	call("GET", "/api/v1/tasks/"+created.TaskID+"/archive", "")
+	call("GET", "/api/v1/tasks/"+created.TaskID+"/signature", "")
+	call("GET", "/api/v1/tasks/missing", "")
+	call("POST", "/api/v1/tasks", `{"links": [{"url": "http://example.com/a.pdf"}]}`)
+
+	var job struct {
+		JobID string `json:"job_id"`
+	}
+	json.Unmarshal(call("POST", "/api/v1/jobs", `{"urls": ["`+fileSrv.URL+`/b.txt"]}`), &job)
+	call("GET", "/api/v1/jobs/"+job.JobID, "")
+	call("GET", "/.well-known/linkzipper-signing-key", "")
+	call("DELETE", "/api/v1/tasks/"+created.TaskID, "")
+}
+
+func TestValidateRequests(t *testing.T) {
+	doc := loadTestSpec(t)
+	validate, err := ValidateRequests(doc)
+	if err != nil {
+		t.Fatalf("validate middleware: %v", err)
+	}
+	r := chi.NewRouter()
+	r.Use(validate)
+	(&API{Manager: NewManager(5, 2, []string{".txt"})}).Mount(r)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path, ct, body string
+		status         int
+		code           string
+	}{
+		{"/api/v1/tasks", "application/json", `{"format": "tar"}`, http.StatusOK, ""},
+		{"/api/v1/tasks", "application/json", `{"format": "rar"}`, http.StatusUnprocessableEntity, "validation_failed"},
+		{"/api/v1/tasks", "application/json", `{"level": "high"}`, http.StatusUnprocessableEntity, "validation_failed"},
+		{"/api/v1/tasks", "text/plain", `{}`, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"/api/v1/tasks/task-1/links", "application/json", `{"name": "a.txt"}`, http.StatusUnprocessableEntity, "validation_failed"},
+		{"/api/v1/jobs", "application/json", `{"urls": "http://example.com/a.txt"}`, http.StatusUnprocessableEntity, "validation_failed"},
+		// устаревшие маршруты в спецификацию не входят и не проверяются
+		{"/tasks", "application/json", `{"format": "tar"}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(ts.URL+tt.path, tt.ct, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("post %s: %v", tt.path, err)
+		}
+		var out struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || out.Error.Code != tt.code {
+			t.Errorf("%s %s: expected %d %q, got %d %q", tt.path, tt.body, tt.status, tt.code, resp.StatusCode, out.Error.Code)
+		}
+	}
+}
+
+func TestOpenAPIEndpoints(t *testing.T) {
+	ts, _ := setupTestServer()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/openapi.json")
+	if err != nil {
+		t.Fatalf("get spec: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if _, err := openapi3.NewLoader().LoadFromData(data); err != nil || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected spec response %s: %v", resp.Header.Get("Content-Type"), err)
+	}
+
+	resp, err = http.Get(ts.URL + "/docs")
+	if err != nil {
+		t.Fatalf("get docs: %v", err)
+	}
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Contains(data, []byte("/openapi.json")) {
+		t.Fatalf("unexpected docs response %d", resp.StatusCode)
+	}
+}
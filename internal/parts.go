@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// minPartSize ограничивает число томов при слишком маленьком max_part_size
+const minPartSize = 1024
+
+// ArchivePart — один том готового архива
+type ArchivePart struct {
+	Path string
+	Size int64
+}
+
+// partWriter раскладывает поток архива по файлам. При limit > 0 архив
+// делится на тома base.001, base.002 и т.д., которые склеиваются обратно
+// обычной конкатенацией. Каждый том отдельно шифруется ключом хранения kr
+type partWriter struct {
+	base    string
+	limit   int64
+	kr      *KeyRing
+	parts   []ArchivePart
+	f       *os.File
+	w       io.Writer
+	enc     io.WriteCloser
+	written int64
+}
+
+func newPartWriter(base string, limit int64, kr *KeyRing) *partWriter {
+	return &partWriter{base: base, limit: limit, kr: kr}
+}
+
+func (p *partWriter) next() error {
+	if err := p.closePart(); err != nil {
+		return err
+	}
+	path := p.base
+	if p.limit > 0 {
+		path += fmt.Sprintf(".%03d", len(p.parts)+1)
+	}
+	if p.kr != nil {
+		path += atRestExt
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	p.f, p.w, p.written = f, f, 0
+	p.parts = append(p.parts, ArchivePart{Path: path})
+	if p.kr != nil {
+		if p.enc, err = p.kr.NewEncryptWriter(f); err != nil {
+			return err
+		}
+		p.w = p.enc
+	}
+	return nil
+}
+
+func (p *partWriter) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		if p.f == nil || (p.limit > 0 && p.written == p.limit) {
+			if err := p.next(); err != nil {
+				return n, err
+			}
+		}
+		chunk := b
+		if p.limit > 0 && int64(len(chunk)) > p.limit-p.written {
+			chunk = chunk[:p.limit-p.written]
+		}
+		c, err := p.w.Write(chunk)
+		n += c
+		p.written += int64(c)
+		if err != nil {
+			return n, err
+		}
+		b = b[c:]
+	}
+	return n, nil
+}
+
+func (p *partWriter) closePart() error {
+	if p.f == nil {
+		return nil
+	}
+	var err error
+	if p.enc != nil {
+		err = p.enc.Close()
+		p.enc = nil
+	}
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+	p.parts[len(p.parts)-1].Size = p.written
+	p.f, p.w = nil, nil
+	return err
+}
+
+// Close завершает последний том; пустой архив всё равно получает один файл
+func (p *partWriter) Close() error {
+	if p.f == nil && len(p.parts) == 0 {
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+	return p.closePart()
+}
+
+// remove удаляет все созданные тома
+func (p *partWriter) remove() {
+	for _, part := range p.parts {
+		os.Remove(part.Path)
+	}
+}
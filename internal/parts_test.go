@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartWriterSplits(t *testing.T) {
+	for _, kr := range []*KeyRing{nil, testKeyRing(t, "k1")} {
+		base := filepath.Join(t.TempDir(), "task-1.zip")
+		pw := newPartWriter(base, 1024, kr)
+		data := make([]byte, 2500)
+		rand.Read(data)
+		pw.Write(data[:100])
+		pw.Write(data[100:])
+		if err := pw.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		if len(pw.parts) != 3 {
+			t.Fatalf("expected 3 parts, got %d", len(pw.parts))
+		}
+		var joined bytes.Buffer
+		for i, part := range pw.parts {
+			want := base + []string{".001", ".002", ".003"}[i]
+			if kr != nil {
+				want += atRestExt
+			}
+			if part.Path != want {
+				t.Fatalf("unexpected part path %s", part.Path)
+			}
+			r, err := OpenArchive(part.Path, kr)
+			if err != nil {
+				t.Fatalf("open part: %v", err)
+			}
+			io.Copy(&joined, r)
+			r.Close()
+		}
+		if pw.parts[2].Size != 452 || !bytes.Equal(joined.Bytes(), data) {
+			t.Fatalf("parts do not join into original data")
+		}
+		pw.remove()
+		if _, err := os.Stat(pw.parts[0].Path); !os.IsNotExist(err) {
+			t.Fatal("parts not removed")
+		}
+	}
+}
+
+func TestPartWriterUnlimited(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "task-1.zip")
+	pw := newPartWriter(base, 0, nil)
+	pw.Write(make([]byte, 5000))
+	pw.Close()
+	if len(pw.parts) != 1 || pw.parts[0].Path != base || pw.parts[0].Size != 5000 {
+		t.Fatalf("unexpected parts %+v", pw.parts)
+	}
+
+	empty := newPartWriter(filepath.Join(t.TempDir(), "task-2.zip"), 1024, nil)
+	empty.Close()
+	if len(empty.parts) != 1 || empty.parts[0].Size != 0 {
+		t.Fatalf("expected single empty part, got %+v", empty.parts)
+	}
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"io"
+	"time"
+)
+
+type FileState string
+
+const (
+	FileQueued      FileState = "queued"
+	FileDownloading FileState = "downloading"
+	FileDone        FileState = "done"
+	FileFailed      FileState = "failed"
+)
+
+// FileProgress описывает ход скачивания одного файла задачи.
+// TotalBytes и ETA равны -1, пока размер файла неизвестен
+type FileProgress struct {
+	URL           string    `json:"url"`
+	State         FileState `json:"state"`
+	BytesReceived int64     `json:"bytes_received"`
+	TotalBytes    int64     `json:"total_bytes"`
+	Throughput    float64   `json:"bytes_per_sec"`
+	ETA           float64   `json:"eta_seconds"`
+	Scan          string    `json:"scan,omitempty"`           // вердикт антивируса: clean, infected, error
+	ScanSignature string    `json:"scan_signature,omitempty"` // название найденной угрозы
+	SHA256        string    `json:"sha256,omitempty"`         // хеш скачанного содержимого
+	DuplicateOf   string    `json:"duplicate_of,omitempty"`   // файл архива с тем же содержимым
+	startedAt     time.Time
+	publishedAt   time.Time // время последнего события о файле в потоке задач
+}
+
+func newFileProgress(url string) *FileProgress {
+	return &FileProgress{URL: url, State: FileQueued, TotalBytes: -1, ETA: -1}
+}
+
+func (fp *FileProgress) update(now time.Time) {
+	if elapsed := now.Sub(fp.startedAt).Seconds(); elapsed > 0 {
+		fp.Throughput = float64(fp.BytesReceived) / elapsed
+	}
+	if fp.TotalBytes >= 0 && fp.Throughput > 0 {
+		fp.ETA = float64(fp.TotalBytes-fp.BytesReceived) / fp.Throughput
+	} else {
+		fp.ETA = -1
+	}
+}
+
+// fraction возвращает долю выполнения файла от 0 до 1
+func (fp *FileProgress) fraction() float64 {
+	switch fp.State {
+	case FileDone, FileFailed:
+		return 1
+	case FileDownloading:
+		if fp.TotalBytes > 0 {
+			return float64(fp.BytesReceived) / float64(fp.TotalBytes)
+		}
+	}
+	return 0
+}
+
+// progressReader обновляет прогресс файла по мере чтения тела ответа.
+// Подписчики узнают о нём не чаще раза в fileEventInterval
+type progressReader struct {
+	r    io.Reader
+	m    *TaskManager
+	task *Task
+	fp   *FileProgress
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		now := time.Now()
+		p.m.mu.Lock()
+		p.fp.BytesReceived += int64(n)
+		p.fp.update(now)
+		if now.Sub(p.fp.publishedAt) >= fileEventInterval {
+			p.m.publishFile(p.task, p.fp)
+		}
+		p.m.mu.Unlock()
+	}
+	return n, err
+}
+
+func (m *TaskManager) setFileState(task *Task, fp *FileProgress, state FileState) {
+	m.mu.Lock()
+	fp.State = state
+	if state == FileDownloading {
+		fp.startedAt = time.Now()
+	}
+	if state == FileDone {
+		fp.ETA = 0
+	}
+	m.publishFile(task, fp)
+	m.mu.Unlock()
+}
+
+// Progress возвращает копию прогресса по файлам и общий процент выполнения задачи
+func (m *TaskManager) Progress(task *Task) ([]FileProgress, float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := make([]FileProgress, len(task.Files))
+	for i, fp := range task.Files {
+		files[i] = *fp
+	}
+	return files, taskProgress(task)
+}
+
+// taskProgress возвращает общий процент выполнения задачи. Вызывается под m.mu
+func taskProgress(task *Task) float64 {
+	if task.Status == StatusComplete {
+		return 100
+	}
+	if len(task.Files) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, fp := range task.Files {
+		sum += fp.fraction()
+	}
+	return 100 * sum / float64(len(task.Files))
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProgressDuringDownload(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(10))
+		w.Write([]byte("12345"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("67890"))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(1, 2, []string{".txt"})
+	id, _ := mgr.Create()
+	mgr.AddURL(id, srv.URL+"/a.txt")
+	task, _ := mgr.Status(id)
+	files, progress := mgr.Progress(task)
+	if len(files) != 1 || files[0].State != FileQueued || progress != 0 {
+		t.Fatalf("unexpected initial progress: %+v %v", files, progress)
+	}
+	if err := mgr.ForceZip(id); err != nil {
+		t.Fatalf("force zip: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		task, _ = mgr.Status(id)
+		files, progress = mgr.Progress(task)
+		if files[0].BytesReceived == 5 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if files[0].State != FileDownloading || files[0].TotalBytes != 10 {
+		t.Fatalf("unexpected file progress: %+v", files[0])
+	}
+	if progress != 50 {
+		t.Fatalf("expected 50%%, got %v", progress)
+	}
+	if files[0].Throughput <= 0 || files[0].ETA < 0 {
+		t.Fatalf("expected throughput and eta, got %+v", files[0])
+	}
+	close(release)
+
+	for i := 0; i < 50; i++ {
+		if task, _ := mgr.Status(id); task.Status == StatusComplete {
+			files, progress = mgr.Progress(task)
+			if files[0].State != FileDone || files[0].BytesReceived != 10 || progress != 100 {
+				t.Fatalf("unexpected final progress: %+v %v", files[0], progress)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("task not completed")
+}
+
+func TestProgressFailedFile(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	mgr := NewManager(1, 1, []string{".txt"})
+	id, _ := mgr.Create()
+	mgr.AddURL(id, srv.URL+"/missing.txt")
+	for i := 0; i < 50; i++ {
+		if task, _ := mgr.Status(id); task.Status == StatusComplete {
+			files, _ := mgr.Progress(task)
+			if files[0].State != FileFailed {
+				t.Fatalf("expected failed state, got %s", files[0].State)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("task not completed")
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"io"
+
+	"filippo.io/age"
+)
+
+const (
+	ageExt         = ".age"
+	ageContentType = "application/x-age-encryption"
+)
+
+// ParseRecipients разбирает открытые ключи получателей age (X25519, "age1...")
+func ParseRecipients(keys []string) ([]age.Recipient, error) {
+	out := make([]age.Recipient, 0, len(keys))
+	for _, k := range keys {
+		r, err := age.ParseX25519Recipient(k)
+		if err != nil {
+			return nil, invalidf("invalid recipient %q: %v", k, err)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// encryptToRecipients оборачивает w в шифрование age; данные становятся
+// доступны получателям только после Close
+func encryptToRecipients(w io.Writer, keys []string) (io.WriteCloser, error) {
+	recipients, err := ParseRecipients(keys)
+	if err != nil {
+		return nil, err
+	}
+	return age.Encrypt(w, recipients...)
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryptToRecipients(t *testing.T) {
+	id1, _ := age.GenerateX25519Identity()
+	id2, _ := age.GenerateX25519Identity()
+	var buf bytes.Buffer
+	enc, err := encryptToRecipients(&buf, []string{id1.Recipient().String(), id2.Recipient().String()})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	enc.Write([]byte("archive"))
+	enc.Close()
+
+	for _, id := range []*age.X25519Identity{id1, id2} {
+		r, err := age.Decrypt(bytes.NewReader(buf.Bytes()), id)
+		if err != nil {
+			t.Fatalf("decrypt: %v", err)
+		}
+		if data, _ := io.ReadAll(r); string(data) != "archive" {
+			t.Fatalf("unexpected plaintext %q", data)
+		}
+	}
+
+	other, _ := age.GenerateX25519Identity()
+	if _, err := age.Decrypt(bytes.NewReader(buf.Bytes()), other); err == nil {
+		t.Fatal("expected decrypt failure for foreign identity")
+	}
+}
+
+func TestParseRecipientsInvalid(t *testing.T) {
+	if _, err := ParseRecipients([]string{"ssh-ed25519 AAAA"}); err == nil {
+		t.Fatal("expected error for invalid recipient")
+	}
+	if err := (TaskOptions{Recipients: []string{"age1invalid"}}).Validate(); err == nil {
+		t.Fatal("expected validation error")
+	}
+}
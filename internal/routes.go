@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// APIPrefix — префикс версионированного API
+const APIPrefix = "/api/v1"
+
+// Даты для заголовков прежних маршрутов: с legacyDeprecated они устарели
+// (появился /api/v1), после legacySunset их планируется убрать
+var (
+	legacyDeprecated = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	legacySunset     = time.Date(2027, time.April, 18, 0, 0, 0, 0, time.UTC)
+)
+
+// Mount регистрирует маршруты сервиса: ресурсные маршруты под /api/v1 и
+// прежние маршруты как устаревшие псевдонимы с заголовком Deprecation
+func (api *API) Mount(r chi.Router) {
+	r.Route(APIPrefix, func(r chi.Router) {
+		r.Get("/tasks", api.ListTasks)
+		r.Get("/tasks/events", api.AllTaskEvents)
+		r.Post("/tasks", api.CreateTask)
+		r.Get("/tasks/{id}", api.GetStatus)
+		r.Delete("/tasks/{id}", api.DeleteTask)
+		r.Post("/tasks/{id}/links", api.AddLink)
+		r.Put("/tasks/{id}/links/{ref}", api.ReplaceLink)
+		r.Delete("/tasks/{id}/links/{ref}", api.RemoveLink)
+		r.Post("/tasks/{id}/archive", api.ForceZip)
+		r.Get("/tasks/{id}/archive", api.Download)
+		r.Get("/tasks/{id}/signature", api.DownloadSignature)
+		r.Post("/tasks/{id}/cancel", api.CancelTask)
+		r.Get("/tasks/{id}/deliveries", api.Deliveries)
+		r.Get("/tasks/{id}/events", api.TaskEvents)
+		r.Post("/jobs", api.CreateJob)
+		r.Get("/jobs/{id}", api.GetJob)
+		r.Get("/jobs/{id}/archive", api.DownloadJob)
+		r.Post("/zip", api.StreamZip)
+		r.Get("/zip", api.StreamZip)
+		r.Post("/verify", api.VerifyArchive)
+	})
+	r.Get("/.well-known/linkzipper-signing-key", api.SigningKey)
+	r.Get("/openapi.json", api.OpenAPI)
+	r.Get("/docs", api.Docs)
+
+	r.With(deprecated(successor("/tasks"))).Post("/tasks", api.CreateTask)
+	r.With(deprecated(successor("/tasks"))).Get("/tasks/list", api.ListTasks)
+	r.With(deprecated(successor("/tasks/{id}"))).Get("/tasks/status/{id}", api.GetStatus)
+	r.With(deprecated(successor("/tasks/{id}"))).Delete("/tasks/delete/{id}", api.DeleteTask)
+	r.With(deprecated(successor("/tasks/{id}/links"))).Post("/tasks/links", api.AddLink)
+	r.With(deprecated(successor("/tasks/{id}/archive"))).Post("/tasks/zip", api.ForceZip)
+	r.With(deprecated(successor("/tasks/{id}/cancel"))).Post("/tasks/{id}/cancel", api.CancelTask)
+	r.With(deprecated(downloadSuccessor)).Get("/download/{id}", api.Download)
+	r.With(deprecated(successor("/jobs"))).Post("/jobs", api.CreateJob)
+	r.With(deprecated(successor("/jobs/{id}"))).Get("/jobs/{id}", api.GetJob)
+	r.With(deprecated(successor("/jobs/{id}/archive"))).Get("/jobs/{id}/download", api.DownloadJob)
+	r.With(deprecated(successor("/zip"))).Post("/zip", api.StreamZip)
+	r.With(deprecated(successor("/zip"))).Get("/zip", api.StreamZip)
+	r.With(deprecated(successor("/verify"))).Post("/verify", api.VerifyArchive)
+}
+
+// successor строит путь замены в /api/v1, подставляя ID из маршрута. Если ID
+// передаётся в теле запроса (прежние /tasks/links и /tasks/zip), путь пустой
+func successor(pattern string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if !strings.Contains(pattern, "{id}") {
+			return APIPrefix + pattern
+		}
+		id := chi.URLParam(r, "id")
+		if id == "" {
+			return ""
+		}
+		return APIPrefix + strings.Replace(pattern, "{id}", id, 1)
+	}
+}
+
+// downloadSuccessor учитывает, что прежний /download/{id} отдаёт и подпись
+func downloadSuccessor(r *http.Request) string {
+	id := chi.URLParam(r, "id")
+	if strings.HasSuffix(id, sigExt) {
+		return APIPrefix + "/tasks/" + strings.TrimSuffix(id, sigExt) + "/signature"
+	}
+	return APIPrefix + "/tasks/" + id + "/archive"
+}
+
+// deprecated помечает ответ устаревшего маршрута заголовками Deprecation
+// (RFC 9745, дата вида @<секунды Unix>) и Sunset (RFC 8594), а в Link даёт
+// ссылку на замену с rel="successor-version" и на документацию с rel="deprecation"
+func deprecated(successor func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecated.Unix()))
+			w.Header().Set("Sunset", legacySunset.Format(http.TimeFormat))
+			links := `</docs>; rel="deprecation"`
+			if link := successor(r); link != "" {
+				links = "<" + link + `>; rel="successor-version", ` + links
+			}
+			w.Header().Set("Link", links)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isV1 сообщает, пришёл ли запрос через /api/v1: от этого зависят ссылки в ответах
+func isV1(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, APIPrefix+"/")
+}
+
+// archiveURL возвращает ссылку на архив задачи для ответа
+func archiveURL(r *http.Request, id string) string {
+	if isV1(r) {
+		return APIPrefix + "/tasks/" + id + "/archive"
+	}
+	return "/download/" + id
+}
+
+// signatureURL возвращает ссылку на подпись архива задачи для ответа
+func signatureURL(r *http.Request, id string) string {
+	if isV1(r) {
+		return APIPrefix + "/tasks/" + id + "/signature"
+	}
+	return "/download/" + id + sigExt
+}
+
+// jobArchiveURL возвращает ссылку на скачивание архивов задания для ответа
+func jobArchiveURL(r *http.Request, id string) string {
+	if isV1(r) {
+		return APIPrefix + "/jobs/" + id + "/archive"
+	}
+	return "/jobs/" + id + "/download"
+}
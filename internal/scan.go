@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ScanResult — вердикт антивируса по одному файлу
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner проверяет скачанный файл до того, как он попадёт в архив
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+}
+
+// ScanPolicy определяет, что делать с заражённым или непроверенным файлом
+type ScanPolicy string
+
+const (
+	ScanDrop       ScanPolicy = "drop"       // не добавлять файл, задача продолжается
+	ScanFail       ScanPolicy = "fail"       // прервать задачу целиком, в том числе при ошибке проверки
+	ScanQuarantine ScanPolicy = "quarantine" // не добавлять файл и сохранить его в карантин
+)
+
+func ParseScanPolicy(s string) (ScanPolicy, error) {
+	switch p := ScanPolicy(s); p {
+	case "":
+		return ScanDrop, nil
+	case ScanDrop, ScanFail, ScanQuarantine:
+		return p, nil
+	}
+	return "", fmt.Errorf("unsupported scan policy %s", s)
+}
+
+// Вердикты проверки в прогрессе файла
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanError    = "error"
+)
+
+// errInfected возвращается для заражённого файла
+type errInfected struct {
+	signature string
+}
+
+func (e *errInfected) Error() string {
+	return "infected: " + e.signature
+}
+
+// errScanFailed возвращается, если файл не удалось проверить
+type errScanFailed struct {
+	err error
+}
+
+func (e *errScanFailed) Error() string {
+	return fmt.Sprintf("scan failed: %v", e.err)
+}
+
+func (e *errScanFailed) Unwrap() error {
+	return e.err
+}
+
+// scanRejected сообщает, что файл не прошёл проверку: заражён или не проверен.
+// При политике fail такой файл прерывает задачу
+func scanRejected(err error) bool {
+	var infected *errInfected
+	var failed *errScanFailed
+	return errors.As(err, &infected) || errors.As(err, &failed)
+}
+
+// SetScanner включает проверку файлов перед добавлением в архив.
+// quarantineDir используется политикой quarantine
+func (m *TaskManager) SetScanner(s Scanner, policy ScanPolicy, quarantineDir string) {
+	m.mu.Lock()
+	m.scanner = s
+	m.scanPolicy = policy
+	m.quarantineDir = quarantineDir
+	m.mu.Unlock()
+}
+
+func (m *TaskManager) policy() ScanPolicy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.scanPolicy
+}
+
+// scanFile проверяет файл f и записывает вердикт в прогресс файла. Ошибка
+// проверки считается отказом: непроверенный файл в архив не попадает
+func (m *TaskManager) scanFile(ctx context.Context, task *Task, i int, f *os.File) error {
+	m.mu.Lock()
+	scanner, policy, dir := m.scanner, m.scanPolicy, m.quarantineDir
+	m.mu.Unlock()
+	fp := task.Files[i]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	res, err := scanner.Scan(ctx, f)
+	if err != nil {
+		m.mu.Lock()
+		fp.Scan = ScanError
+		m.mu.Unlock()
+		return &errScanFailed{err: err}
+	}
+	m.mu.Lock()
+	if res.Infected {
+		fp.Scan = ScanInfected
+		fp.ScanSignature = res.Signature
+	} else {
+		fp.Scan = ScanClean
+	}
+	m.mu.Unlock()
+	if !res.Infected {
+		return nil
+	}
+	Logger.WithField("task_id", task.ID).WithField("url", task.Urls[i]).Warnf("infected file: %s", res.Signature)
+	if policy == ScanQuarantine {
+		if err := quarantine(f, dir, fmt.Sprintf("%s-%d-%s", task.ID, i+1, filepath.Base(fp.URL))); err != nil {
+			Logger.WithError(err).WithField("task_id", task.ID).Error("quarantine failed")
+		}
+	}
+	return &errInfected{signature: res.Signature}
+}
+
+// quarantine копирует заражённый файл в каталог карантина
+func quarantine(f *os.File, dir, name string) error {
+	if dir == "" {
+		return errors.New("quarantine directory is not configured")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	name = sanitizePath(strings.NewReplacer("?", "_", "/", "_").Replace(name))
+	out, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, f)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// clamdChunk — размер блока данных в команде INSTREAM
+const clamdChunk = 64 * 1024
+
+// ClamdScanner проверяет файлы через демон clamd командой INSTREAM
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner создаёт клиент clamd по адресу вида tcp://host:3310
+// или unix:///var/run/clamav/clamd.ctl
+func NewClamdScanner(address string, timeout time.Duration) (*ClamdScanner, error) {
+	network, addr, ok := strings.Cut(address, "://")
+	if !ok || addr == "" || (network != "tcp" && network != "unix") {
+		return nil, fmt.Errorf("invalid clamd address %s", address)
+	}
+	return &ClamdScanner{network: network, address: addr, timeout: timeout}, nil
+}
+
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	d := net.Dialer{Timeout: c.timeout}
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else if c.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, err
+	}
+	buf := make([]byte, 4+clamdChunk)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, werr := conn.Write(buf[:4+n]); werr != nil {
+				return ScanResult{}, werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return ScanResult{}, err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return ScanResult{}, err
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return ScanResult{}, err
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply разбирает ответ вида "stream: OK" или "stream: Eicar FOUND"
+func parseClamdReply(reply string) (ScanResult, error) {
+	_, verdict, _ := strings.Cut(reply, ": ")
+	switch {
+	case verdict == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	}
+	return ScanResult{}, fmt.Errorf("clamd: %s", reply)
+}
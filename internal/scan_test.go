@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd отвечает на INSTREAM как clamd: данные со строкой EICAR считаются заражёнными
+func fakeClamd(t *testing.T, network, address string) string {
+	t.Helper()
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				cmd, err := br.ReadString(0)
+				if err != nil || cmd != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var data bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					io.CopyN(&data, br, int64(size))
+				}
+				if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+	return network + "://" + ln.Addr().String()
+}
+
+func TestClamdScanner(t *testing.T) {
+	addrs := []string{
+		fakeClamd(t, "tcp", "127.0.0.1:0"),
+		fakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.sock")),
+	}
+	for _, addr := range addrs {
+		s, err := NewClamdScanner(addr, time.Second)
+		if err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+		clean := bytes.Repeat([]byte("clean data "), 20000)
+		if res, err := s.Scan(context.Background(), bytes.NewReader(clean)); err != nil || res.Infected {
+			t.Fatalf("%s: expected clean, got %+v %v", addr, res, err)
+		}
+		res, err := s.Scan(context.Background(), strings.NewReader("X5O!P%@AP EICAR test"))
+		if err != nil || !res.Infected || res.Signature != "Eicar-Test-Signature" {
+			t.Fatalf("%s: expected infected, got %+v %v", addr, res, err)
+		}
+	}
+	if _, err := NewClamdScanner("localhost:3310", time.Second); err == nil {
+		t.Fatal("expected error for address without scheme")
+	}
+	if _, err := parseClamdReply("stream: Size limit exceeded ERROR"); err == nil {
+		t.Fatal("expected error reply")
+	}
+}
+
+func scanTask(t *testing.T, policy ScanPolicy, clamd string, quarantineDir string) (*TaskManager, *Task) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/virus.txt" {
+			w.Write([]byte("EICAR"))
+			return
+		}
+		w.Write([]byte("clean"))
+	}))
+	t.Cleanup(srv.Close)
+	scanner, _ := NewClamdScanner(clamd, time.Second)
+	mgr := NewManager(1, 2, []string{".txt"})
+	mgr.SetScanner(scanner, policy, quarantineDir)
+	id, _ := mgr.Create()
+	mgr.AddURL(id, srv.URL+"/virus.txt")
+	mgr.AddURL(id, srv.URL+"/clean.txt")
+	for i := 0; i < 50; i++ {
+		task, _ := mgr.Status(id)
+		if task.Status == StatusComplete || task.Status == StatusFailed {
+			return mgr, task
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("task not finished")
+	return nil, nil
+}
+
+func TestScanPolicyDrop(t *testing.T) {
+	mgr, task := scanTask(t, ScanDrop, fakeClamd(t, "tcp", "127.0.0.1:0"), "")
+	defer mgr.Delete(task.ID)
+	files, _ := mgr.Progress(task)
+	if files[0].Scan != ScanInfected || files[0].ScanSignature != "Eicar-Test-Signature" || files[1].Scan != ScanClean {
+		t.Fatalf("unexpected verdicts %+v", files)
+	}
+	if !strings.Contains(task.Errors[files[0].URL], "infected") {
+		t.Fatalf("expected infected error, got %v", task.Errors)
+	}
+	data, _ := os.ReadFile(task.ZipPath)
+	if got := readArchive(t, FormatZip, data); len(got) != 1 || got["clean.txt"] != "clean" {
+		t.Fatalf("unexpected archive contents %v", got)
+	}
+}
+
+func TestScanPolicyFail(t *testing.T) {
+	mgr, task := scanTask(t, ScanFail, fakeClamd(t, "tcp", "127.0.0.1:0"), "")
+	defer mgr.Delete(task.ID)
+	if task.Status != StatusFailed || !strings.Contains(task.Failure, "Eicar") {
+		t.Fatalf("expected failed task, got %s %q", task.Status, task.Failure)
+	}
+	if task.ZipPath != "" || len(task.Parts) != 0 {
+		t.Fatal("expected no archive for failed task")
+	}
+}
+
+func TestScanPolicyQuarantine(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "quarantine")
+	mgr, task := scanTask(t, ScanQuarantine, fakeClamd(t, "tcp", "127.0.0.1:0"), dir)
+	defer mgr.Delete(task.ID)
+	data, err := os.ReadFile(filepath.Join(dir, task.ID+"-1-virus.txt"))
+	if err != nil || string(data) != "EICAR" {
+		t.Fatalf("expected quarantined file, got %q %v", data, err)
+	}
+}
+
+func TestScanUnavailable(t *testing.T) {
+	mgr, task := scanTask(t, ScanDrop, "unix://"+filepath.Join(t.TempDir(), "missing.sock"), "")
+	defer mgr.Delete(task.ID)
+	files, _ := mgr.Progress(task)
+	for _, f := range files {
+		if f.Scan != ScanError || f.State != FileFailed {
+			t.Fatalf("expected scan error for %+v", f)
+		}
+	}
+}
+
+func TestScanUnavailablePolicyFail(t *testing.T) {
+	mgr, task := scanTask(t, ScanFail, "unix://"+filepath.Join(t.TempDir(), "missing.sock"), "")
+	defer mgr.Delete(task.ID)
+	if task.Status != StatusFailed || !strings.Contains(task.Failure, "scan failed") {
+		t.Fatalf("expected failed task, got %s %q", task.Status, task.Failure)
+	}
+	if task.ZipPath != "" || len(task.Parts) != 0 {
+		t.Fatal("expected no archive for failed task")
+	}
+}
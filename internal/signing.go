@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// sigExt — суффикс адреса отделённой подписи архива: /download/{id}.sig
+const sigExt = ".sig"
+
+// Signer подписывает SHA-256 готовых архивов ключом Ed25519 сервера
+type Signer struct {
+	priv ed25519.PrivateKey
+}
+
+// LoadSigner читает закрытый ключ из конфига: 32-байтный seed или 64-байтный
+// ключ Ed25519 в base64, прямо в конфиге или в файле. Без ключа подпись
+// выключена и возвращается nil
+func LoadSigner(cfg SigningConfig) (*Signer, error) {
+	encoded := cfg.Key
+	if cfg.File != "" {
+		data, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("read signing key: %v", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decode signing key: %v", err)
+	}
+	return NewSigner(raw)
+}
+
+// NewSigner создаёт подписчика из seed или полного закрытого ключа Ed25519
+func NewSigner(key []byte) (*Signer, error) {
+	switch len(key) {
+	case ed25519.SeedSize:
+		return &Signer{priv: ed25519.NewKeyFromSeed(key)}, nil
+	case ed25519.PrivateKeySize:
+		return &Signer{priv: ed25519.PrivateKey(key)}, nil
+	}
+	return nil, errors.New("signing key must be a 32-byte seed or 64-byte private key")
+}
+
+// PublicKey возвращает открытый ключ для проверки подписей
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.priv.Public().(ed25519.PublicKey)
+}
+
+// Sign подписывает SHA-256 архива
+func (s *Signer) Sign(digest []byte) []byte {
+	return ed25519.Sign(s.priv, digest)
+}
+
+// VerifyArchive считает SHA-256 архива из r и проверяет подпись открытым ключом pub.
+// Возвращает дайджест архива, чтобы вызывающий мог сообщить его пользователю
+func VerifyArchive(pub ed25519.PublicKey, r io.Reader, sig []byte) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	digest := h.Sum(nil)
+	if len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, digest, sig) {
+		return digest, errors.New("signature mismatch")
+	}
+	return digest, nil
+}
+
+// DecodeSignature разбирает подпись в base64, как её отдаёт /download/{id}.sig
+func DecodeSignature(s string) ([]byte, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, invalidf("invalid signature")
+	}
+	return sig, nil
+}
@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSigner(t *testing.T) {
+	if s, err := LoadSigner(SigningConfig{}); s != nil || err != nil {
+		t.Fatalf("expected disabled signer, got %v %v", s, err)
+	}
+	seed := bytes.Repeat([]byte{1}, ed25519.SeedSize)
+	path := filepath.Join(t.TempDir(), "signing.key")
+	os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(seed)+"\n"), 0o600)
+	fromFile, err := LoadSigner(SigningConfig{File: path})
+	if err != nil {
+		t.Fatalf("load from file: %v", err)
+	}
+	full := ed25519.NewKeyFromSeed(seed)
+	inline, err := LoadSigner(SigningConfig{Key: base64.StdEncoding.EncodeToString(full)})
+	if err != nil {
+		t.Fatalf("load inline: %v", err)
+	}
+	if !bytes.Equal(fromFile.PublicKey(), inline.PublicKey()) {
+		t.Fatal("seed and full key give different public keys")
+	}
+	if _, err := LoadSigner(SigningConfig{Key: base64.StdEncoding.EncodeToString([]byte("short"))}); err == nil {
+		t.Fatal("expected error for short key")
+	}
+}
+
+func TestVerifyArchive(t *testing.T) {
+	s, _ := NewSigner(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	data := []byte("archive contents")
+	digest, _ := VerifyArchive(s.PublicKey(), bytes.NewReader(data), make([]byte, ed25519.SignatureSize))
+	sig := s.Sign(digest)
+	if _, err := VerifyArchive(s.PublicKey(), bytes.NewReader(data), sig); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if _, err := VerifyArchive(s.PublicKey(), bytes.NewReader([]byte("other")), sig); err == nil {
+		t.Fatal("expected mismatch for other data")
+	}
+	other, _ := NewSigner(bytes.Repeat([]byte{3}, ed25519.SeedSize))
+	if _, err := VerifyArchive(other.PublicKey(), bytes.NewReader(data), sig); err == nil {
+		t.Fatal("expected mismatch for other key")
+	}
+	if _, err := DecodeSignature("bm90IGEgc2ln"); err == nil {
+		t.Fatal("expected error for short signature")
+	}
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const manifestName = "manifest.json"
+
+// ValidateLinks проверяет набор ссылок по тем же правилам, что и AddURL
+func (m *TaskManager) ValidateLinks(urls []string) error {
+	if len(urls) == 0 {
+		return ErrNoFiles
+	}
+	if len(urls) > m.maxFiles {
+		return ErrMaxFiles
+	}
+	for i, url := range urls {
+		if err := m.checkURL(urls[:i], url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stream синхронно скачивает ссылки и пишет zip прямо в w без временных файлов.
+// Ошибки по отдельным файлам попадают в завершающий manifest.json, так как
+// статус ответа к этому моменту уже отправлен. Ссылки должны быть проверены
+// через ValidateLinks; Stream занимает слот обработки на время работы
+func (m *TaskManager) Stream(ctx context.Context, urls []string, w io.Writer) error {
+	m.mu.Lock()
+	if m.inProcess >= m.maxTasks {
+		m.mu.Unlock()
+		err := ErrServerBusy
+		Logger.WithError(err).Error("stream zip failed")
+		return err
+	}
+	m.inProcess++
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.inProcess--
+		m.mu.Unlock()
+	}()
+
+	task := &Task{ID: "stream", Urls: urls, Errors: make(map[string]string)}
+	for _, url := range urls {
+		task.Links = append(task.Links, Link{URL: url})
+		task.Files = append(task.Files, newFileProgress(url))
+	}
+	Logger.WithField("urls", len(urls)).Info("stream zip started")
+
+	m.mu.Lock()
+	opts := TaskOptions{Format: FormatZip}.withDefaults(m.defaults)
+	m.mu.Unlock()
+	zw, err := newArchiveWriter(w, opts, m.storeExts, "")
+	if err != nil {
+		return err
+	}
+	m.writeArchive(ctx, task, zw, nil)
+	if err := ctx.Err(); err != nil {
+		Logger.WithError(err).Error("stream zip aborted")
+		return err
+	}
+	if task.Failure != "" {
+		err := errors.New(task.Failure)
+		Logger.WithError(err).Error("stream zip aborted")
+		return err
+	}
+
+	files, _ := m.Progress(task)
+	mw, err := zw.Create(manifestName, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(mw).Encode(map[string]interface{}{
+		"files":  files,
+		"errors": task.Errors,
+	}); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	Logger.WithFields(logrus.Fields{"urls": len(urls), "errors": len(task.Errors)}).Info("stream zip finished")
+	return nil
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateLinks(t *testing.T) {
+	mgr := NewManager(1, 2, []string{".txt"})
+	cases := map[string][]string{
+		"empty":     nil,
+		"too many":  {"http://a/1.txt", "http://a/2.txt", "http://a/3.txt"},
+		"bad ext":   {"http://a/1.exe"},
+		"invalid":   {"ftp://a/1.txt"},
+		"duplicate": {"http://a/1.txt", "http://a/1.txt?x=1"},
+	}
+	for name, urls := range cases {
+		if err := mgr.ValidateLinks(urls); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if err := mgr.ValidateLinks([]string{"http://a/1.txt", "http://a/2.txt"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestStreamWritesManifest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ok.txt" {
+			w.Write([]byte("ok"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(1, 3, []string{".txt"})
+	sub, _, _ := mgr.Subscribe("", 0)
+	defer mgr.Unsubscribe(sub)
+	okURL, badURL := srv.URL+"/ok.txt", srv.URL+"/bad.txt"
+	var buf bytes.Buffer
+	if err := mgr.Stream(context.Background(), []string{okURL, badURL}, &buf); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	select {
+	case ev := <-sub.C:
+		t.Fatalf("stream published an event %+v", ev)
+	default:
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "ok.txt" || zr.File[1].Name != manifestName {
+		t.Fatalf("unexpected entries: %v", zr.File)
+	}
+	rc, _ := zr.File[1].Open()
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	var manifest struct {
+		Errors map[string]string `json:"errors"`
+		Files  []FileProgress    `json:"files"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if _, ok := manifest.Errors[badURL]; !ok || len(manifest.Errors) != 1 {
+		t.Fatalf("unexpected manifest errors: %v", manifest.Errors)
+	}
+	if len(manifest.Files) != 2 || manifest.Files[0].State != FileDone || manifest.Files[1].State != FileFailed {
+		t.Fatalf("unexpected manifest files: %+v", manifest.Files)
+	}
+	if _, err := mgr.Create(); err != nil {
+		t.Fatalf("slot not released: %v", err)
+	}
+}
+
+func TestStreamServerBusy(t *testing.T) {
+	mgr := NewManager(1, 3, []string{".txt"})
+	mgr.inProcess = 1
+	var buf bytes.Buffer
+	if err := mgr.Stream(context.Background(), []string{"http://a/1.txt"}, &buf); err == nil {
+		t.Fatal("expected busy error")
+	}
+	if buf.Len() != 0 {
+		t.Fatal("nothing should be written when busy")
+	}
+}
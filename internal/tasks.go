@@ -1,7 +1,8 @@
 package internal
 
 import (
-	"archive/zip"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -9,6 +10,8 @@ import (
 	neturl "net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,18 +22,133 @@ import (
 type TaskStatus string
 
 const (
-	StatusPending  TaskStatus = "pending"
+	StatusPending    TaskStatus = "pending"
 	StatusProcessing TaskStatus = "processing"
-	StatusComplete TaskStatus = "complete"
+	StatusComplete   TaskStatus = "complete"
+	StatusCancelled  TaskStatus = "cancelled"
+	StatusFailed     TaskStatus = "failed"
 )
 
+// TaskOptions задаёт параметры сборки архива. Незаполненные поля
+// берутся из настроек менеджера
+type TaskOptions struct {
+	Format      ArchiveFormat `json:"format,omitempty"`
+	Compression Compression   `json:"compression,omitempty"`
+	Level       *int          `json:"level,omitempty"`
+	AutoStore   *bool         `json:"auto_store,omitempty"`    // не сжимать уже сжатые типы файлов
+	Encrypt     bool          `json:"encrypt,omitempty"`       // шифрование zip по WinZip AES-256
+	Password    string        `json:"-"`                       // используется только при создании задачи
+	Recipients  []string      `json:"recipients,omitempty"`    // открытые ключи age, которым шифруется готовый архив
+	MaxPartSize int64         `json:"max_part_size,omitempty"` // размер тома при разбиении архива, 0 — без разбиения
+	Layout      string        `json:"layout,omitempty"`        // flat, host, path или шаблон вида {host}/{index}_{name}
+	Extract     bool          `json:"extract,omitempty"`       // распаковывать скачанные zip и tar.gz в каталоги архива
+	Dedup       Dedup         `json:"dedup,omitempty"`         // skip или alias для файлов с одинаковым содержимым
+	CallbackURL string        `json:"callback_url,omitempty"`  // адрес вебхука о завершении задачи
+	// Deterministic включает воспроизводимую сборку: одинаковый набор файлов
+	// даёт побайтно одинаковый архив
+	Deterministic bool `json:"deterministic,omitempty"`
+}
+
+func (o TaskOptions) Validate() error {
+	if _, err := ParseFormat(string(o.Format)); err != nil {
+		return err
+	}
+	if _, err := ParseCompression(string(o.Compression)); err != nil {
+		return err
+	}
+	if o.Level != nil && (*o.Level < DefaultLevel || *o.Level > 9) {
+		return invalidf("compression level %d out of range", *o.Level)
+	}
+	if _, err := ParseRecipients(o.Recipients); err != nil {
+		return err
+	}
+	if o.MaxPartSize != 0 && o.MaxPartSize < minPartSize {
+		return invalidf("max_part_size must be at least %d bytes", minPartSize)
+	}
+	if _, err := ParseDedup(string(o.Dedup)); err != nil {
+		return err
+	}
+	if o.CallbackURL != "" {
+		if err := checkCallbackURL(o.CallbackURL); err != nil {
+			return err
+		}
+	}
+	if o.Deterministic {
+		if o.Compression != "" || o.Level != nil || o.AutoStore != nil {
+			return invalidf("deterministic mode uses fixed compression settings")
+		}
+		if o.Encrypt || len(o.Recipients) > 0 {
+			return invalidf("deterministic mode is incompatible with encryption")
+		}
+	}
+	return validateLayout(o.Layout)
+}
+
+// ArchiveExt возвращает расширение готового архива с учётом шифрования age
+func (o TaskOptions) ArchiveExt() string {
+	if len(o.Recipients) > 0 {
+		return o.Format.Ext() + ageExt
+	}
+	return o.Format.Ext()
+}
+
+func (o TaskOptions) ContentType() string {
+	if len(o.Recipients) > 0 {
+		return ageContentType
+	}
+	return o.Format.ContentType()
+}
+
+func (o TaskOptions) withDefaults(d TaskOptions) TaskOptions {
+	if o.Format == "" {
+		o.Format = d.Format
+	}
+	if o.Format == "" {
+		o.Format = FormatZip
+	}
+	if o.Deterministic {
+		// настройки сервера не должны влиять на хеш архива
+		autoStore := false
+		o.Compression = CompressionDeflate
+		o.Level = nil
+		o.AutoStore = &autoStore
+		return o
+	}
+	if o.Compression == "" {
+		o.Compression = d.Compression
+	}
+	if o.Compression == "" {
+		o.Compression = CompressionDeflate
+	}
+	if o.Level == nil {
+		o.Level = d.Level
+	}
+	if o.AutoStore == nil {
+		o.AutoStore = d.AutoStore
+	}
+	return o
+}
+
 type Task struct {
-	ID          string
-	Urls        []string
-	Errors      map[string]string
-	ZipPath     string
-	Status      TaskStatus
-	createdAt   time.Time
+	ID        string
+	Urls      []string
+	Links     []Link // ссылки с именами и каталогами в архиве, параллельно Urls
+	Files     []*FileProgress
+	Errors    map[string]string
+	Options   TaskOptions
+	ZipPath   string           // путь к готовому архиву в формате Options.Format
+	Parts     []ArchivePart    // тома архива; для неразбитого архива один том с ZipPath
+	Digest    []byte           // SHA-256 всего архива (для томов — их склейки)
+	Signature []byte           // подпись Digest ключом Ed25519 сервера, если он настроен
+	Version   int              // номер текущей версии архива, 0 — архив ещё не собран
+	Versions  []ArchiveVersion // сохранённые предыдущие версии
+	Failure   string           // причина прерывания задачи со статусом failed или неудачной сборки новой версии
+	entries   []string         // имена файлов текущей версии по ссылкам; "" — файл не попал в архив
+	password  string           // пароль шифрования zip, хранится, только пока задача ждёт сборки или собирается
+	Status    TaskStatus
+	createdAt time.Time
+	cancel    context.CancelFunc
+	discard   bool
 }
 
 var idCounter uint64
@@ -44,6 +162,19 @@ type TaskManager struct {
 	maxTasks  int
 	maxFiles  int
 	exts      map[string]struct{}
+	defaults  TaskOptions
+	storeExts map[string]struct{}
+	keyring   *KeyRing
+	signer    *Signer
+	// keepVersions — сколько предыдущих версий архива хранить, 0 — все
+	keepVersions  int
+	extractLimits ExtractLimits
+	scanner       Scanner
+	scanPolicy    ScanPolicy
+	quarantineDir string
+	jobs          map[string]*Job
+	webhooks      *Webhooks
+	events        *eventBroker
 }
 
 func NewManager(maxTasks, maxFiles int, allowedExts []string) *TaskManager {
@@ -54,104 +185,321 @@ func NewManager(maxTasks, maxFiles int, allowedExts []string) *TaskManager {
 	return &TaskManager{
 		tasks:     make(map[string]*Task),
 		completed: make(map[string]*Task),
+		jobs:      make(map[string]*Job),
+		events:    newEventBroker(),
 		maxTasks:  maxTasks,
 		maxFiles:  maxFiles,
 		exts:      exts,
+		storeExts: storeExtSet(nil),
 	}
 }
 
-func (m *TaskManager) Create() (string, error) {
+// SetDefaults задаёт опции, применяемые к задачам без явных настроек
+func (m *TaskManager) SetDefaults(opts TaskOptions) {
+	m.mu.Lock()
+	m.defaults = opts
+	m.mu.Unlock()
+}
+
+// defaultStoreExtensions — расширения уже сжатых файлов, которые кладутся
+// в zip без сжатия, если в конфиге список не задан
+var defaultStoreExtensions = []string{
+	".jpeg", ".jpg", ".png", ".gif", ".webp",
+	".zip", ".gz", ".tgz", ".zst", ".7z", ".rar",
+	".mp3", ".mp4", ".mkv", ".webm",
+}
+
+// SetStoreExtensions задаёт расширения уже сжатых файлов, которые при
+// включённом AutoStore кладутся в zip без сжатия. Пустой список заменяется
+// на defaultStoreExtensions
+func (m *TaskManager) SetStoreExtensions(exts []string) {
+	set := storeExtSet(exts)
+	m.mu.Lock()
+	m.storeExts = set
+	m.mu.Unlock()
+}
+
+func storeExtSet(exts []string) map[string]struct{} {
+	if len(exts) == 0 {
+		exts = defaultStoreExtensions
+	}
+	set := make(map[string]struct{}, len(exts))
+	for _, e := range exts {
+		set[strings.ToLower(e)] = struct{}{}
+	}
+	return set
+}
+
+// SetKeyRing включает шифрование готовых архивов на диске
+func (m *TaskManager) SetKeyRing(kr *KeyRing) {
+	m.mu.Lock()
+	m.keyring = kr
+	m.mu.Unlock()
+}
+
+// SetSigner включает подпись готовых архивов
+func (m *TaskManager) SetSigner(s *Signer) {
+	m.mu.Lock()
+	m.signer = s
+	m.mu.Unlock()
+}
+
+// Signer возвращает ключ подписи архивов или nil, если подпись выключена
+func (m *TaskManager) Signer() *Signer {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.signer
+}
+
+// OpenArchive открывает архив версии v, расшифровывая его при необходимости
+func (m *TaskManager) OpenArchive(v ArchiveVersion) (io.ReadSeekCloser, error) {
+	if v.ZipPath == "" {
+		return nil, ErrArchiveSplit
+	}
+	return m.openFile(v.ZipPath)
+}
+
+// OpenPart открывает том архива версии v с номером n, начиная с 1
+func (m *TaskManager) OpenPart(v ArchiveVersion, n int) (io.ReadSeekCloser, error) {
+	if n < 1 || n > len(v.Parts) {
+		return nil, ErrPartNotFound
+	}
+	return m.openFile(v.Parts[n-1].Path)
+}
 
+func (m *TaskManager) openFile(path string) (io.ReadSeekCloser, error) {
+	m.mu.Lock()
+	kr := m.keyring
+	m.mu.Unlock()
+	return OpenArchive(path, kr)
+}
+
+func (m *TaskManager) Create() (string, error) {
+	return m.CreateWithOptions(TaskOptions{})
+}
+
+// ValidateOptions проверяет опции задачи с учётом настроек менеджера по умолчанию
+func (m *TaskManager) ValidateOptions(opts TaskOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	opts = opts.withDefaults(m.defaults)
+	webhooks := m.webhooks
+	m.mu.Unlock()
+	if opts.CallbackURL != "" && webhooks == nil {
+		return invalidf("webhooks are not configured")
+	}
+	if opts.Encrypt {
+		if opts.Format != FormatZip {
+			return invalidf("encryption requires zip format")
+		}
+		if opts.Password == "" {
+			return invalidf("password required for encryption")
+		}
+	}
+	return nil
+}
+
+func (m *TaskManager) CreateWithOptions(opts TaskOptions) (string, error) {
+	id, _, err := m.CreateBatch(opts, nil, false)
+	return id, err
+}
+
+// LinkError — ошибка проверки одной ссылки при пакетном создании задачи
+type LinkError struct {
+	Index int    `json:"index"`
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// CheckLinks проверяет ссылки по тем же правилам, что и AddLink, но не
+// останавливается на первой ошибке, а возвращает ошибки по каждой ссылке
+func (m *TaskManager) CheckLinks(links []Link) []LinkError {
+	return m.checkLinks(links, m.maxFiles)
+}
+
+// checkLinks проверяет ссылки; limit — допустимое число ссылок, 0 — без ограничения
+func (m *TaskManager) checkLinks(links []Link, limit int) []LinkError {
+	var errs []LinkError
+	accepted := make([]string, 0, len(links))
+	for i, link := range links {
+		err := m.checkURL(accepted, link.URL)
+		if err == nil && limit > 0 && len(accepted) >= limit {
+			err = ErrMaxFiles
+		}
+		if err != nil {
+			errs = append(errs, LinkError{Index: i, URL: link.URL, Error: err.Error()})
+			continue
+		}
+		accepted = append(accepted, link.URL)
+	}
+	return errs
+}
+
+// CreateBatch создаёт задачу сразу со ссылками. Задача создаётся, только
+// если все ссылки прошли проверку; иначе возвращаются ошибки по ссылкам.
+// При start или заполнении лимита файлов обработка начинается сразу
+func (m *TaskManager) CreateBatch(opts TaskOptions, links []Link, start bool) (string, []LinkError, error) {
+	if err := m.ValidateOptions(opts); err != nil {
+		Logger.WithError(err).Error("create task failed")
+		return "", nil, err
+	}
+	if start && len(links) == 0 {
+		err := ErrNoFiles
+		Logger.WithError(err).Error("create task failed")
+		return "", nil, err
+	}
+	if errs := m.CheckLinks(links); len(errs) > 0 {
+		err := ErrInvalidLinks
+		Logger.WithError(err).WithField("rejected", len(errs)).Error("create task failed")
+		return "", errs, err
+	}
+	password := opts.Password
+	opts.Password = ""
+	m.mu.Lock()
 	if m.inProcess >= m.maxTasks {
-		err := errors.New("server busy: max tasks reached")
+		m.mu.Unlock()
+		err := ErrServerBusy
 		Logger.WithError(err).Error("create task failed")
-		return "", err
+		return "", nil, err
 	}
 
 	id := fmt.Sprintf("task-%d", atomic.AddUint64(&idCounter, 1))
-	m.tasks[id] = &Task{ID: id, Urls: []string{}, Errors: make(map[string]string), Status: StatusPending, createdAt: time.Now()}
-	Logger.WithField("task_id", id).Info("task created")
-	return id, nil
+	task := &Task{
+		ID:        id,
+		Urls:      []string{},
+		Links:     []Link{},
+		Errors:    make(map[string]string),
+		Options:   opts.withDefaults(m.defaults),
+		password:  password,
+		Status:    StatusPending,
+		createdAt: time.Now(),
+	}
+	for _, link := range links {
+		task.Urls = append(task.Urls, link.URL)
+		task.Links = append(task.Links, link)
+		task.Files = append(task.Files, newFileProgress(link.URL))
+	}
+	m.tasks[id] = task
+	var ctx context.Context
+	if start || (len(links) > 0 && len(links) == m.maxFiles) {
+		ctx = m.start(task)
+	}
+	m.publishTask(task)
+	m.mu.Unlock()
+
+	Logger.WithFields(logrus.Fields{"task_id": id, "links": len(links)}).Info("task created")
+	if ctx != nil {
+		Logger.WithField("task_id", id).Info("processing started")
+		go m.process(ctx, task)
+	}
+	return id, nil, nil
 }
 
 func (m *TaskManager) AddURL(id, url string) error {
+	return m.AddLink(id, Link{URL: url})
+}
+
+// AddLink добавляет ссылку с необязательными именем файла и каталогом в архиве.
+// Завершённая задача открывается заново: после сборки появится новая версия архива
+func (m *TaskManager) AddLink(id string, link Link) error {
+	url := link.URL
 	m.mu.Lock()
 	task, ok := m.tasks[id]
+	reopen := false
 	if !ok {
-		m.mu.Unlock()
-		if _, done := m.completed[id]; done {
-			err := errors.New("task already completed")
+		done, found := m.completed[id]
+		if !found {
+			m.mu.Unlock()
+			err := ErrTaskNotFound
+			Logger.WithError(err).WithField("task_id", id).Error("add url failed")
+			return err
+		}
+		if err := canReopen(done); err != nil {
+			m.mu.Unlock()
 			Logger.WithError(err).WithField("task_id", id).Error("add url failed")
 			return err
 		}
-		err := errors.New("task not found")
+		task, reopen = done, true
+	}
+	if task.Status != StatusPending && !reopen {
+		m.mu.Unlock()
+		err := ErrTaskProcessing
 		Logger.WithError(err).WithField("task_id", id).Error("add url failed")
 		return err
 	}
-	if task.Status != StatusPending {
+	// лимит считается по ссылкам, добавленным после последней сборки
+	pending := len(task.Urls) - len(task.entries)
+	if pending >= m.maxFiles {
 		m.mu.Unlock()
-		err := errors.New("task already processing")
+		err := ErrMaxFiles
 		Logger.WithError(err).WithField("task_id", id).Error("add url failed")
 		return err
 	}
-	if len(task.Urls) >= m.maxFiles {
+	if err := m.checkURL(task.Urls, url); err != nil {
 		m.mu.Unlock()
-		err := errors.New("max files per task reached")
 		Logger.WithError(err).WithField("task_id", id).Error("add url failed")
 		return err
 	}
-	parsed, err := neturl.Parse(url)
-	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+	// сборка запускается, когда ссылок после правок стало ровно maxFilesPerTask;
+	// занятость проверяется до изменения задачи, чтобы отказ не оставил ссылку
+	shouldZip := pending+1 == m.maxFiles
+	if shouldZip && m.inProcess >= m.maxTasks {
 		m.mu.Unlock()
-		err := errors.New("invalid URL")
+		err := ErrServerBusy
 		Logger.WithError(err).WithField("task_id", id).Error("add url failed")
 		return err
 	}
+	if reopen {
+		delete(m.completed, id)
+		m.tasks[id] = task
+		task.Status = StatusPending
+		task.Failure = ""
+		Logger.WithFields(logrus.Fields{"task_id": id, "version": task.Version}).Info("task reopened")
+	}
+
+	task.Urls = append(task.Urls, url)
+	task.Links = append(task.Links, link)
+	task.Files = append(task.Files, newFileProgress(url))
+	var ctx context.Context
+	if shouldZip {
+		ctx = m.start(task)
+	}
+	m.publishTask(task)
+	m.mu.Unlock()
+
+	if shouldZip {
+		Logger.WithField("task_id", id).Info("processing started")
+		go m.process(ctx, task)
+	} else {
+		Logger.WithFields(logrus.Fields{"task_id": id, "url": url}).Info("url added")
+	}
+	return nil
+}
+
+// checkURL проверяет схему, расширение и уникальность ссылки среди уже добавленных
+func (m *TaskManager) checkURL(existing []string, url string) error {
+	parsed, err := neturl.Parse(url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return invalidf("invalid URL")
+	}
 	ext := filepath.Ext(parsed.Path)
 	if _, allowed := m.exts[ext]; !allowed {
-		m.mu.Unlock()
-		err := fmt.Errorf("extension %s not allowed", ext)
-		Logger.WithError(err).WithField("task_id", id).Error("add url failed")
-		return err
+		return invalidf("extension %s not allowed", ext)
 	}
 
 	normalized := *parsed
 	normalized.RawQuery = ""
 	normalized.Fragment = ""
 	normStr := normalized.String()
-	for _, u := range task.Urls {
+	for _, u := range existing {
 		exParsed, _ := neturl.Parse(u)
 		exParsed.RawQuery = ""
 		exParsed.Fragment = ""
 		if exParsed.String() == normStr {
-			m.mu.Unlock()
-			err := errors.New("this link already exists")
-			Logger.WithError(err).WithField("task_id", id).Error("add url failed")
-			return err
-		}
-	}
-	
-	task.Urls = append(task.Urls, url)
-	shouldZip := len(task.Urls) == m.maxFiles
-	if shouldZip {
-		if m.inProcess >= m.maxTasks {
-			m.mu.Unlock()
-			err := errors.New("server busy: max tasks reached")
-			Logger.WithError(err).WithField("task_id", id).Error("add url failed")
-			return err
+			return invalidf("this link already exists")
 		}
-		m.inProcess++
-		task.Status = StatusProcessing
-	}
-	m.mu.Unlock()
-
-	if shouldZip {
-		Logger.WithField("task_id", id).Info("processing started")
-		go m.process(task)
-	} else {
-		Logger.WithFields(logrus.Fields{"task_id": id, "url": url}).Info("url added")
 	}
 	return nil
 }
@@ -162,119 +510,395 @@ func (m *TaskManager) ForceZip(id string) error {
 	if !ok {
 		m.mu.Unlock()
 		if _, done := m.completed[id]; done {
-			err := errors.New("task already completed")
+			err := ErrTaskCompleted
 			Logger.WithError(err).WithField("task_id", id).Error("force zip failed")
 			return err
 		}
-		err := errors.New("task not found")
+		err := ErrTaskNotFound
 		Logger.WithError(err).WithField("task_id", id).Error("force zip failed")
 		return err
 	}
 	if task.Status != StatusPending {
 		m.mu.Unlock()
-		err := errors.New("task already processing")
+		err := ErrTaskProcessing
 		Logger.WithError(err).WithField("task_id", id).Error("force zip failed")
 		return err
 	}
-	if len(task.Urls) == 0 {
+	if len(task.Urls) == len(task.entries) {
 		m.mu.Unlock()
-		err := errors.New("no files to archive")
+		err := ErrNoFiles
 		Logger.WithError(err).WithField("task_id", id).Error("force zip failed")
 		return err
 	}
 	if m.inProcess >= m.maxTasks {
 		m.mu.Unlock()
-		err := errors.New("server busy: max tasks reached")
+		err := ErrServerBusy
 		Logger.WithError(err).WithField("task_id", id).Error("force zip failed")
 		return err
 	}
-	m.inProcess++
-	task.Status = StatusProcessing
+	ctx := m.start(task)
+	m.publishTask(task)
 	m.mu.Unlock()
 
 	Logger.WithField("task_id", id).Info("manual processing started")
-	go m.process(task)
+	go m.process(ctx, task)
 	return nil
 }
 
-func (m *TaskManager) process(task *Task) {
+// start переводит задачу в обработку и занимает слот; вызывается под m.mu
+func (m *TaskManager) start(task *Task) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.inProcess++
+	task.Status = StatusProcessing
+	task.cancel = cancel
+	return ctx
+}
+
+func (m *TaskManager) process(ctx context.Context, task *Task) {
+	m.mu.Lock()
+	kr := m.keyring
+	signer := m.signer
+	version := task.Version + 1
+	m.mu.Unlock()
 	tmpDir := os.TempDir()
-	zipName := fmt.Sprintf("%s.zip", task.ID)
-	zipPath := filepath.Join(tmpDir, zipName)
-	f, _ := os.Create(zipPath)
-	defer f.Close()
-	zw := zip.NewWriter(f)
-	defer zw.Close()
-
-	for _, url := range task.Urls {
-		resp, err := http.Get(url)
+	zipName := task.ID + task.Options.ArchiveExt()
+	if version > 1 {
+		zipName = fmt.Sprintf("%s-v%d%s", task.ID, version, task.Options.ArchiveExt())
+	}
+	pw := newPartWriter(filepath.Join(tmpDir, zipName), task.Options.MaxPartSize, kr)
+	h := sha256.New()
+	entries, err := m.buildArchive(ctx, task, io.MultiWriter(pw, h))
+	if cerr := pw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		Logger.WithError(err).WithField("task_id", task.ID).Error("archive build failed")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cancelled := ctx.Err() != nil
+	m.inProcess--
+	task.cancel()
+	task.cancel = nil
+	task.password = ""
+	delete(m.tasks, task.ID)
+	if err != nil && !cancelled && task.Failure == "" {
+		task.Failure = err.Error()
+	}
+	if task.Failure != "" {
+		pw.remove()
+		if task.Version > 0 {
+			// недособранная версия не публикуется: задача остаётся с прежним архивом
+			m.revert(task)
+			Logger.WithField("task_id", task.ID).Errorf("new archive version failed: %s", task.Failure)
+		} else {
+			task.Status = StatusFailed
+			Logger.WithField("task_id", task.ID).Errorf("task failed: %s", task.Failure)
+			m.notify(task)
+		}
+	} else if cancelled {
+		pw.remove()
+		if task.Version > 0 {
+			m.revert(task)
+			Logger.WithField("task_id", task.ID).Info("new archive version cancelled")
+		} else {
+			task.Status = StatusCancelled
+			Logger.WithField("task_id", task.ID).Info("task cancelled")
+			m.notify(task)
+		}
+	} else {
+		if task.Version > 0 {
+			task.Versions = append(task.Versions, currentVersion(task))
+		}
+		task.Version = version
+		task.entries = entries
+		task.ZipPath = ""
+		task.Parts = pw.parts
+		task.Digest = h.Sum(nil)
+		if signer != nil {
+			task.Signature = signer.Sign(task.Digest)
+		}
+		if task.Options.MaxPartSize == 0 {
+			task.ZipPath = pw.parts[0].Path
+		}
+		task.Status = StatusComplete
+		m.pruneVersions(task)
+		Logger.WithFields(logrus.Fields{"task_id": task.ID, "version": version}).Info("task completed")
+		m.notify(task)
+	}
+	if task.discard {
+		removeFiles(task)
+	} else {
+		m.completed[task.ID] = task
+		m.publishTask(task)
+	}
+}
+
+// buildArchive пишет архив задачи в w, при необходимости шифруя его для
+// получателей age, и возвращает имена записей по ссылкам для новой версии
+func (m *TaskManager) buildArchive(ctx context.Context, task *Task, w io.Writer) ([]string, error) {
+	var enc io.WriteCloser
+	if len(task.Options.Recipients) > 0 {
+		var err error
+		if enc, err = encryptToRecipients(w, task.Options.Recipients); err != nil {
+			return nil, err
+		}
+		w = enc
+	}
+	aw, err := newArchiveWriter(w, task.Options, m.storeExts, task.password)
+	if err != nil {
+		return nil, err
+	}
+	var prev *prevArchive
+	if task.Version > 0 {
+		if prev, err = m.openPrevious(task); err != nil {
+			return nil, err
+		}
+		defer prev.Close()
+	}
+	entries := m.writeArchive(ctx, task, aw, prev)
+	if task.Options.Dedup == DedupAlias && ctx.Err() == nil {
+		if err := m.writeAliases(task, aw); err != nil {
+			return nil, err
+		}
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	if enc != nil {
+		return entries, enc.Close()
+	}
+	return entries, nil
+}
+
+// writeArchive скачивает ссылки задачи в архив, записывая ошибки и прогресс по файлам.
+// Файлы, уже собранные в prev, копируются из него без скачивания. Прерывается при отмене ctx.
+// Возвращает имена записей по ссылкам; "" — файл не попал в архив
+func (m *TaskManager) writeArchive(ctx context.Context, task *Task, aw ArchiveWriter, prev *prevArchive) []string {
+	order := make([]int, len(task.Urls))
+	for i := range order {
+		order[i] = i
+	}
+	if task.Options.Deterministic {
+		// порядок записей не зависит от порядка добавления ссылок
+		sort.SliceStable(order, func(a, b int) bool { return task.Urls[order[a]] < task.Urls[order[b]] })
+	}
+	links := make([]Link, len(order))
+	fixed := make([]string, len(order))
+	for k, i := range order {
+		links[k] = task.Links[i]
+		if i < len(task.entries) {
+			fixed[k] = task.entries[i]
+		}
+	}
+	sorted := entryNames(links, task.Options.Layout, fixed)
+	names := make([]string, len(order))
+	for k, i := range order {
+		names[i] = sorted[k]
+	}
+	var seen map[string]string
+	if task.Options.Dedup != "" {
+		seen = contentIndex(task)
+	}
+	written := make([]string, len(order))
+	for _, i := range order {
+		url := task.Urls[i]
+		if ctx.Err() != nil {
+			break
+		}
+		fp := task.Files[i]
+		if i < len(task.entries) {
+			if task.entries[i] == "" || prev == nil {
+				continue
+			}
+			if err := prev.copyTo(aw, names[i]); err != nil {
+				m.setError(task, fp, err.Error())
+				Logger.WithError(err).WithField("url", url).Error("copy from previous version failed")
+				continue
+			}
+			written[i] = names[i]
+			continue
+		}
+		m.setFileState(task, fp, FileDownloading)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			m.setError(task, fp, err.Error())
+			Logger.WithError(err).WithField("url", url).Error("download failed")
+			continue
+		}
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
-			task.Errors[url] = err.Error()
+			if ctx.Err() != nil {
+				break
+			}
+			m.setError(task, fp, err.Error())
 			Logger.WithError(err).WithField("url", url).Error("download failed")
 			continue
 		}
 		if resp.StatusCode != http.StatusOK {
-			task.Errors[url] = fmt.Sprintf("status %d", resp.StatusCode)
+			m.setError(task, fp, fmt.Sprintf("status %d", resp.StatusCode))
 			Logger.WithField("url", url).Errorf("status %d", resp.StatusCode)
 			resp.Body.Close()
 			continue
 		}
-		fname := filepath.Base(url)
-		w, _ := zw.Create(fname)
-		if _, err := io.Copy(w, resp.Body); err != nil {
-			task.Errors[url] = err.Error()
+		m.mu.Lock()
+		fp.TotalBytes = resp.ContentLength
+		m.mu.Unlock()
+		fname, err := m.addDownloaded(ctx, task, aw, i, names[i], lastModified(resp), &progressReader{r: resp.Body, m: m, task: task, fp: fp}, seen)
+		resp.Body.Close()
+		var dup *errDuplicate
+		if errors.As(err, &dup) {
+			m.mu.Lock()
+			fp.DuplicateOf = dup.entry
+			m.mu.Unlock()
+			m.setFileState(task, fp, FileDone)
+			Logger.WithFields(logrus.Fields{"task_id": task.ID, "url": url, "entry": dup.entry}).Info("duplicate content skipped")
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			m.setError(task, fp, err.Error())
 			Logger.WithError(err).WithField("url", url).Error("write failed")
-		} else {
-			Logger.WithFields(logrus.Fields{"task_id": task.ID, "file": fname}).Info("file added")
+			if scanRejected(err) && m.policy() == ScanFail {
+				m.mu.Lock()
+				task.Failure = fmt.Sprintf("%s: %v", url, err)
+				m.mu.Unlock()
+				break
+			}
+			continue
 		}
-		resp.Body.Close()
+		m.setFileState(task, fp, FileDone)
+		written[i] = fname
+		if seen != nil {
+			seen[fp.SHA256] = fname
+		}
+		Logger.WithFields(logrus.Fields{"task_id": task.ID, "file": fname}).Info("file added")
 	}
-	task.ZipPath = zipPath
-	task.Status = StatusComplete
+	return written
+}
+
+// lastModified возвращает время изменения файла по заголовку Last-Modified
+// источника, а если его нет — время скачивания
+func lastModified(resp *http.Response) time.Time {
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+func (m *TaskManager) setError(task *Task, fp *FileProgress, msg string) {
 	m.mu.Lock()
-	m.inProcess--
-	delete(m.tasks, task.ID)
-	m.completed[task.ID] = task
-	Logger.WithField("task_id", task.ID).Info("task completed")
+	task.Errors[fp.URL] = msg
+	fp.State = FileFailed
+	m.publishFile(task, fp)
 	m.mu.Unlock()
 }
 
+// Cancel останавливает задачу: ожидающая задача отменяется сразу,
+// обрабатываемая — после прерывания загрузок в process
+func (m *TaskManager) Cancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	task, ok := m.tasks[id]
+	if !ok {
+		if _, done := m.completed[id]; done {
+			err := ErrTaskCompleted
+			Logger.WithError(err).WithField("task_id", id).Error("cancel task failed")
+			return err
+		}
+		err := ErrTaskNotFound
+		Logger.WithError(err).WithField("task_id", id).Error("cancel task failed")
+		return err
+	}
+	if task.Status == StatusProcessing {
+		task.cancel()
+		Logger.WithField("task_id", id).Info("task cancellation requested")
+		return nil
+	}
+	delete(m.tasks, id)
+	m.completed[id] = task
+	task.password = ""
+	if task.Version > 0 {
+		m.revert(task)
+		m.publishTask(task)
+		Logger.WithField("task_id", id).Info("task reopen cancelled")
+		return nil
+	}
+	task.Status = StatusCancelled
+	m.publishTask(task)
+	Logger.WithField("task_id", id).Info("task cancelled")
+	m.notify(task)
+	return nil
+}
+
+// Status возвращает снимок задачи, снятый под m.mu. Поля снимка можно
+// читать, пока задача обрабатывается; последующие изменения в нём не видны
 func (m *TaskManager) Status(id string) (*Task, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if task, ok := m.tasks[id]; ok {
-		return task, nil
+		return snapshot(task), nil
 	}
 	if task, ok := m.completed[id]; ok {
-		return task, nil
+		return snapshot(task), nil
 	}
-	return nil, errors.New("task not found")
+	return nil, ErrTaskNotFound
 }
 
+// List возвращает снимки всех задач
 func (m *TaskManager) List() []*Task {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	out := make([]*Task, 0, len(m.tasks)+len(m.completed))
 	for _, t := range m.tasks {
-		out = append(out, t)
+		out = append(out, snapshot(t))
 	}
 	for _, t := range m.completed {
-		out = append(out, t)
+		out = append(out, snapshot(t))
 	}
 	return out
 }
 
+// snapshot копирует задачу вместе с изменяемыми при обработке полями.
+// Вызывается под m.mu
+func snapshot(task *Task) *Task {
+	c := *task
+	c.Urls = append([]string{}, task.Urls...)
+	c.Links = append([]Link{}, task.Links...)
+	c.Files = make([]*FileProgress, len(task.Files))
+	for i, fp := range task.Files {
+		f := *fp
+		c.Files[i] = &f
+	}
+	c.Errors = make(map[string]string, len(task.Errors))
+	for k, v := range task.Errors {
+		c.Errors[k] = v
+	}
+	c.Versions = append([]ArchiveVersion(nil), task.Versions...)
+	c.entries = append([]string(nil), task.entries...)
+	c.password = ""
+	c.cancel = nil
+	return &c
+}
+
 func (m *TaskManager) Delete(id string) error {
 	m.mu.Lock()
 	task, ok := m.tasks[id]
 	if ok {
 		if task.Status == StatusProcessing {
-			m.mu.Unlock()
-			err := errors.New("task in progress")
-			Logger.WithError(err).WithField("task_id", id).Error("delete task failed")
-			return err
+			task.discard = true
+			task.cancel()
+		} else {
+			task.password = ""
+			removeFiles(task)
 		}
 		delete(m.tasks, id)
+		m.notifyExpired(task, archiveVersions(task), true)
+		m.publishDeleted(id)
+		m.pruneJobs(id)
 		Logger.WithField("task_id", id).Info("task deleted")
 		m.mu.Unlock()
 		return nil
@@ -282,15 +906,16 @@ func (m *TaskManager) Delete(id string) error {
 	task, ok = m.completed[id]
 	if ok {
 		delete(m.completed, id)
-		if task.ZipPath != "" {
-			os.Remove(task.ZipPath)
-		}
+		removeFiles(task)
+		m.notifyExpired(task, archiveVersions(task), true)
+		m.publishDeleted(id)
+		m.pruneJobs(id)
 		Logger.WithField("task_id", id).Info("task deleted")
 		m.mu.Unlock()
 		return nil
 	}
 	m.mu.Unlock()
-	err := errors.New("task not found")
+	err := ErrTaskNotFound
 	Logger.WithError(err).WithField("task_id", id).Error("delete task failed")
 	return err
-}
\ No newline at end of file
+}
@@ -2,11 +2,19 @@ package internal
 
 import (
 	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"filippo.io/age"
 )
 
 func TestCreateUniqueIDs(t *testing.T) {
@@ -171,3 +179,372 @@ func TestProcessSkipsInvalidLinks(t *testing.T) {
 	}
 	t.Fatal("task not completed")
 }
+
+func TestCancelProcessing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(1, 3, []string{".txt"})
+	id, _ := mgr.Create()
+	if err := mgr.AddURL(id, srv.URL+"/slow.txt"); err != nil {
+		t.Fatalf("add url: %v", err)
+	}
+	if err := mgr.ForceZip(id); err != nil {
+		t.Fatalf("force zip: %v", err)
+	}
+	if err := mgr.Cancel(id); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	zipPath := filepath.Join(os.TempDir(), id+".zip")
+	for i := 0; i < 50; i++ {
+		task, _ := mgr.Status(id)
+		if task.Status == StatusCancelled {
+			if task.ZipPath != "" {
+				t.Fatalf("expected empty zip path, got %s", task.ZipPath)
+			}
+			if _, err := os.Stat(zipPath); !os.IsNotExist(err) {
+				t.Fatalf("partial archive not removed: %v", err)
+			}
+			if _, err := mgr.Create(); err != nil {
+				t.Fatalf("slot not freed: %v", err)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("task not cancelled")
+}
+
+func TestCancelPending(t *testing.T) {
+	mgr := NewManager(1, 3, []string{".txt"})
+	id, _ := mgr.Create()
+	if err := mgr.Cancel(id); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	task, _ := mgr.Status(id)
+	if task.Status != StatusCancelled {
+		t.Fatalf("expected cancelled, got %s", task.Status)
+	}
+	if err := mgr.AddURL(id, "http://example.com/file.txt"); err == nil {
+		t.Fatal("expected error adding url to cancelled task")
+	}
+	if err := mgr.Cancel(id); err == nil {
+		t.Fatal("expected error cancelling twice")
+	}
+
+	// пароль отменённой задачи не остаётся в памяти
+	id, _ = mgr.CreateWithOptions(TaskOptions{Encrypt: true, Password: "p"})
+	mgr.Cancel(id)
+	mgr.mu.Lock()
+	password := mgr.completed[id].password
+	mgr.mu.Unlock()
+	if password != "" {
+		t.Fatal("password kept after cancel")
+	}
+}
+
+func TestDeleteProcessing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(1, 3, []string{".txt"})
+	id, _ := mgr.Create()
+	mgr.AddURL(id, srv.URL+"/slow.txt")
+	if err := mgr.ForceZip(id); err != nil {
+		t.Fatalf("force zip: %v", err)
+	}
+	if err := mgr.Delete(id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := mgr.Status(id); err == nil {
+		t.Fatal("expected error for deleted task")
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := mgr.Create(); err == nil {
+			if len(mgr.List()) != 1 {
+				t.Fatalf("deleted task reappeared")
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("slot not freed after delete")
+}
+
+func TestProcessTarGz(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(1, 1, []string{".txt"})
+	mgr.SetDefaults(TaskOptions{Format: FormatTar})
+	id, err := mgr.CreateWithOptions(TaskOptions{Format: FormatTarGz})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	mgr.AddURL(id, srv.URL+"/f.txt")
+	for i := 0; i < 50; i++ {
+		task, _ := mgr.Status(id)
+		if task.Status == StatusComplete {
+			if filepath.Ext(task.ZipPath) != ".gz" {
+				t.Fatalf("unexpected archive path %s", task.ZipPath)
+			}
+			data, err := os.ReadFile(task.ZipPath)
+			if err != nil {
+				t.Fatalf("read archive: %v", err)
+			}
+			if files := readArchive(t, FormatTarGz, data); files["f.txt"] != "ok" {
+				t.Fatalf("unexpected contents %v", files)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("task not completed")
+}
+
+func TestCreateWithDefaults(t *testing.T) {
+	mgr := NewManager(1, 1, []string{".txt"})
+	mgr.SetDefaults(TaskOptions{Format: FormatTarZst})
+	id, _ := mgr.Create()
+	task, _ := mgr.Status(id)
+	if task.Options.Format != FormatTarZst {
+		t.Fatalf("expected default format, got %s", task.Options.Format)
+	}
+	if _, err := mgr.CreateWithOptions(TaskOptions{Format: "rar"}); err == nil {
+		t.Fatal("expected error for unsupported format")
+	}
+}
+
+func TestTaskOptionsValidate(t *testing.T) {
+	bad := 10
+	if err := (TaskOptions{Level: &bad}).Validate(); err == nil {
+		t.Fatal("expected error for level out of range")
+	}
+	if err := (TaskOptions{Compression: "lzma"}).Validate(); err == nil {
+		t.Fatal("expected error for unsupported compression")
+	}
+	level := 1
+	opts := TaskOptions{}.withDefaults(TaskOptions{Compression: CompressionStore, Level: &level})
+	if opts.Format != FormatZip || opts.Compression != CompressionStore || *opts.Level != 1 {
+		t.Fatalf("unexpected defaults: %+v", opts)
+	}
+}
+
+func TestEncryptedTask(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(1, 1, []string{".txt"})
+	if _, err := mgr.CreateWithOptions(TaskOptions{Encrypt: true}); err == nil {
+		t.Fatal("expected error without password")
+	}
+	if _, err := mgr.CreateWithOptions(TaskOptions{Encrypt: true, Password: "p", Format: FormatTar}); err == nil {
+		t.Fatal("expected error for non-zip format")
+	}
+	id, err := mgr.CreateWithOptions(TaskOptions{Encrypt: true, Password: "p"})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	task, _ := mgr.Status(id)
+	if task.Options.Password != "" {
+		t.Fatal("password stored in task options")
+	}
+	mgr.AddURL(id, srv.URL+"/f.txt")
+	for i := 0; i < 50; i++ {
+		if task.Status == StatusComplete {
+			zr, err := zip.OpenReader(task.ZipPath)
+			if err != nil {
+				t.Fatalf("open zip: %v", err)
+			}
+			defer zr.Close()
+			if got := decryptAESEntry(t, zr.File[0], "p"); string(got) != "ok" {
+				t.Fatalf("unexpected contents %q", got)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		task, _ = mgr.Status(id)
+	}
+	t.Fatal("task not completed")
+}
+
+func TestRecipientEncryptedTask(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	identity, _ := age.GenerateX25519Identity()
+	mgr := NewManager(1, 1, []string{".txt"})
+	id, err := mgr.CreateWithOptions(TaskOptions{Format: FormatTarGz, Recipients: []string{identity.Recipient().String()}})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	mgr.AddURL(id, srv.URL+"/f.txt")
+	for i := 0; i < 50; i++ {
+		task, _ := mgr.Status(id)
+		if task.Status == StatusComplete {
+			if !strings.HasSuffix(task.ZipPath, ".tar.gz.age") {
+				t.Fatalf("unexpected archive path %s", task.ZipPath)
+			}
+			f, _ := os.Open(task.ZipPath)
+			defer f.Close()
+			r, err := age.Decrypt(f, identity)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			data, _ := io.ReadAll(r)
+			if files := readArchive(t, FormatTarGz, data); files["f.txt"] != "ok" {
+				t.Fatalf("unexpected contents %v", files)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("task not completed")
+}
+
+func TestProcessUsesLastModified(t *testing.T) {
+	modified := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dated.txt" {
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(1, 2, []string{".txt"})
+	id, _ := mgr.Create()
+	before := time.Now().Add(-time.Second)
+	mgr.AddURL(id, srv.URL+"/dated.txt")
+	mgr.AddURL(id, srv.URL+"/undated.txt")
+	for i := 0; i < 50; i++ {
+		task, _ := mgr.Status(id)
+		if task.Status == StatusComplete {
+			zr, err := zip.OpenReader(task.ZipPath)
+			if err != nil {
+				t.Fatalf("open zip: %v", err)
+			}
+			defer zr.Close()
+			if !zr.File[0].Modified.Equal(modified) {
+				t.Fatalf("expected origin time %v, got %v", modified, zr.File[0].Modified)
+			}
+			if zr.File[1].Modified.Before(before) {
+				t.Fatalf("expected fetch time, got %v", zr.File[1].Modified)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("task not completed")
+}
+
+func TestDeterministicTaskDigest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", time.Now().Format(http.TimeFormat))
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(2, 3, []string{".txt"})
+	mgr.SetDefaults(TaskOptions{Compression: CompressionStore})
+	urls := []string{srv.URL + "/a.txt", srv.URL + "/b.txt", srv.URL + "/c.txt"}
+	build := func(order []int) *Task {
+		id, err := mgr.CreateWithOptions(TaskOptions{Deterministic: true})
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		for _, i := range order {
+			mgr.AddURL(id, urls[i])
+		}
+		for i := 0; i < 50; i++ {
+			task, _ := mgr.Status(id)
+			if task.Status == StatusComplete {
+				return task
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatal("task not completed")
+		return nil
+	}
+	first := build([]int{0, 1, 2})
+	time.Sleep(1100 * time.Millisecond)
+	second := build([]int{2, 0, 1})
+
+	a, _ := os.ReadFile(first.ZipPath)
+	b, _ := os.ReadFile(second.ZipPath)
+	if !bytes.Equal(a, b) {
+		t.Fatal("archives differ")
+	}
+	sum := sha256.Sum256(a)
+	if !bytes.Equal(first.Digest, sum[:]) || !bytes.Equal(second.Digest, sum[:]) {
+		t.Fatal("digest does not match archive")
+	}
+	zr, _ := zip.NewReader(bytes.NewReader(a), int64(len(a)))
+	if zr.File[0].Name != "a.txt" || zr.File[0].Method != zip.Deflate {
+		t.Fatalf("unexpected first entry %s method %d", zr.File[0].Name, zr.File[0].Method)
+	}
+}
+
+func TestDeterministicOptionsValidation(t *testing.T) {
+	level := 5
+	for _, opts := range []TaskOptions{
+		{Deterministic: true, Level: &level},
+		{Deterministic: true, Compression: CompressionStore},
+		{Deterministic: true, Encrypt: true, Password: "x"},
+	} {
+		if err := opts.Validate(); err == nil {
+			t.Fatalf("expected error for %+v", opts)
+		}
+	}
+}
+
+func TestCreateBatch(t *testing.T) {
+	fileSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer fileSrv.Close()
+
+	mgr := NewManager(2, 3, []string{".txt"})
+	links := []Link{
+		{URL: fileSrv.URL + "/a.txt"},
+		{URL: fileSrv.URL + "/b.exe"},
+		{URL: fileSrv.URL + "/a.txt?x=1"},
+		{URL: fileSrv.URL + "/c.txt"},
+		{URL: fileSrv.URL + "/d.txt"},
+		{URL: fileSrv.URL + "/e.txt"},
+	}
+	id, errs, err := mgr.CreateBatch(TaskOptions{}, links, false)
+	if err == nil || id != "" {
+		t.Fatal("expected batch to be rejected")
+	}
+	if len(errs) != 3 || errs[0].Index != 1 || errs[1].Index != 2 || errs[2].Index != 5 {
+		t.Fatalf("unexpected link errors %+v", errs)
+	}
+	if len(mgr.List()) != 0 {
+		t.Fatal("rejected batch must not create a task")
+	}
+
+	id, errs, err = mgr.CreateBatch(TaskOptions{}, links[:1], true)
+	if err != nil || errs != nil {
+		t.Fatalf("create batch: %v %+v", err, errs)
+	}
+	for i := 0; i < 50; i++ {
+		if task, _ := mgr.Status(id); task.Status == StatusComplete {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("started batch task not completed")
+}
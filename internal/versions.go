@@ -0,0 +1,387 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveVersion — одна собранная версия архива задачи. Завершённую задачу
+// можно дополнить ссылками: новая версия копирует файлы предыдущей без
+// повторного скачивания, а старые версии хранятся до удаления по keepVersions
+type ArchiveVersion struct {
+	Number    int
+	ZipPath   string
+	Parts     []ArchivePart
+	Digest    []byte
+	Signature []byte
+}
+
+// Size возвращает размер архива версии (для томов — их суммарный размер)
+func (v ArchiveVersion) Size() int64 {
+	var size int64
+	for _, p := range v.Parts {
+		size += p.Size
+	}
+	return size
+}
+
+func currentVersion(task *Task) ArchiveVersion {
+	return ArchiveVersion{
+		Number:    task.Version,
+		ZipPath:   task.ZipPath,
+		Parts:     task.Parts,
+		Digest:    task.Digest,
+		Signature: task.Signature,
+	}
+}
+
+// SetKeepVersions задаёт, сколько предыдущих версий архива хранить; 0 — все
+func (m *TaskManager) SetKeepVersions(n int) {
+	m.mu.Lock()
+	m.keepVersions = n
+	m.mu.Unlock()
+}
+
+// Version возвращает версию архива с номером n; 0 означает текущую
+func (m *TaskManager) Version(task *Task, n int) (ArchiveVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n == 0 || n == task.Version {
+		return currentVersion(task), nil
+	}
+	for _, v := range task.Versions {
+		if v.Number == n {
+			return v, nil
+		}
+	}
+	return ArchiveVersion{}, fmt.Errorf("%w: %d", ErrVersionNotFound, n)
+}
+
+// canReopen проверяет, можно ли дополнить завершённую задачу новыми ссылками.
+// Для зашифрованных архивов пароля уже нет, поэтому их нельзя пересобрать
+func canReopen(task *Task) error {
+	if task.Status != StatusComplete {
+		return ErrTaskCompleted
+	}
+	if task.Options.Encrypt || len(task.Options.Recipients) > 0 {
+		return ErrTaskEncrypted
+	}
+	return nil
+}
+
+// revert отменяет дополнение задачи: убирает ссылки, добавленные после
+// последней сборки, и возвращает задаче текущую версию. Вызывается под m.mu
+func (m *TaskManager) revert(task *Task) {
+	n := len(task.entries)
+	for _, url := range task.Urls[n:] {
+		for key := range task.Errors {
+			if key == url || strings.HasPrefix(key, url+"#") {
+				delete(task.Errors, key)
+			}
+		}
+	}
+	task.Urls = task.Urls[:n]
+	task.Links = task.Links[:n]
+	task.Files = task.Files[:n]
+	task.Status = StatusComplete
+}
+
+// pruneVersions удаляет предыдущие версии сверх keepVersions. Вызывается под m.mu
+func (m *TaskManager) pruneVersions(task *Task) {
+	if m.keepVersions <= 0 || len(task.Versions) <= m.keepVersions {
+		return
+	}
+	drop := len(task.Versions) - m.keepVersions
+	expired := make([]int, 0, drop)
+	for _, v := range task.Versions[:drop] {
+		for _, part := range v.Parts {
+			os.Remove(part.Path)
+		}
+		expired = append(expired, v.Number)
+		Logger.WithFields(logrus.Fields{"task_id": task.ID, "version": v.Number}).Info("archive version removed")
+	}
+	task.Versions = append([]ArchiveVersion(nil), task.Versions[drop:]...)
+	m.notifyExpired(task, expired, false)
+}
+
+// archiveVersions возвращает номера всех собранных версий архива задачи
+func archiveVersions(task *Task) []int {
+	var out []int
+	for _, v := range task.Versions {
+		out = append(out, v.Number)
+	}
+	if task.Version > 0 {
+		out = append(out, task.Version)
+	}
+	return out
+}
+
+// removeFiles удаляет с диска все версии архива задачи
+func removeFiles(task *Task) {
+	for _, part := range task.Parts {
+		os.Remove(part.Path)
+	}
+	for _, v := range task.Versions {
+		for _, part := range v.Parts {
+			os.Remove(part.Path)
+		}
+	}
+}
+
+// openVersion открывает архив версии целиком, склеивая тома
+func (m *TaskManager) openVersion(v ArchiveVersion) (io.ReadSeekCloser, error) {
+	c := &concatReader{}
+	for _, p := range v.Parts {
+		r, err := m.openFile(p.Path)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.parts = append(c.parts, r)
+		c.sizes = append(c.sizes, p.Size)
+		c.size += p.Size
+	}
+	return c, nil
+}
+
+// concatReader читает несколько томов как один поток с поддержкой Seek
+type concatReader struct {
+	parts []io.ReadSeekCloser
+	sizes []int64
+	size  int64
+	pos   int64
+}
+
+func (c *concatReader) Read(p []byte) (int, error) {
+	off := c.pos
+	for i, r := range c.parts {
+		if off >= c.sizes[i] {
+			off -= c.sizes[i]
+			continue
+		}
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if rest := c.sizes[i] - off; int64(len(p)) > rest {
+			p = p[:rest]
+		}
+		n, err := r.Read(p)
+		c.pos += int64(n)
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+func (c *concatReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.pos
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	c.pos = offset
+	return offset, nil
+}
+
+func (c *concatReader) Close() error {
+	var err error
+	for _, r := range c.parts {
+		if cerr := r.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// readerAt позволяет открыть zip поверх ReadSeeker
+type readerAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+func (r *readerAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.rs, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// prevArchive читает записи предыдущей версии для копирования в новую.
+// Записи zip копируются без повторного сжатия; tar читается последовательно,
+// поэтому записи запрашиваются в том же порядке, в каком были записаны
+type prevArchive struct {
+	src     io.ReadSeekCloser
+	files   []*zip.File
+	tr      *tar.Reader
+	pending *tar.Header
+	closer  func()
+}
+
+func (m *TaskManager) openPrevious(task *Task) (*prevArchive, error) {
+	m.mu.Lock()
+	v := currentVersion(task)
+	m.mu.Unlock()
+	src, err := m.openVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	p := &prevArchive{src: src}
+	var r io.Reader = src
+	switch task.Options.Format {
+	case FormatZip:
+		zr, err := zip.NewReader(&readerAt{rs: src}, v.Size())
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		p.files = zr.File
+		return p, nil
+	case FormatTarGz:
+		gr, err := gzip.NewReader(src)
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		r = gr
+	case FormatTarZst:
+		zr, err := zstd.NewReader(src)
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		p.closer = zr.Close
+		r = zr
+	}
+	p.tr = tar.NewReader(r)
+	return p, nil
+}
+
+// matches сообщает, относится ли запись к файлу name; имя со слэшем на
+// конце — каталог распакованного вложенного архива со всем содержимым
+func matches(entry, name string) bool {
+	if strings.HasSuffix(name, "/") {
+		return strings.HasPrefix(entry, name)
+	}
+	return entry == name
+}
+
+// copyTo переносит запись name (или все записи каталога name/) из
+// предыдущей версии в aw
+func (p *prevArchive) copyTo(aw ArchiveWriter, name string) error {
+	found := false
+	if p.files != nil {
+		zw, ok := aw.(*zipWriter)
+		if !ok {
+			return errors.New("previous version format mismatch")
+		}
+		for _, f := range p.files {
+			if !matches(f.Name, name) {
+				continue
+			}
+			if err := zw.zw.Copy(f); err != nil {
+				return err
+			}
+			found = true
+		}
+	} else {
+		for {
+			hdr := p.pending
+			p.pending = nil
+			if hdr == nil {
+				var err error
+				if hdr, err = p.tr.Next(); err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+			}
+			if !matches(hdr.Name, name) {
+				if found {
+					// записи одного файла идут подряд; следующая относится к другой ссылке
+					p.pending = hdr
+					break
+				}
+				continue
+			}
+			w, err := aw.Create(hdr.Name, hdr.ModTime)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, p.tr); err != nil {
+				return err
+			}
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("entry %s not found in previous version", name)
+	}
+	return nil
+}
+
+// copyAll переносит в aw все записи архива, переименовывая записи с именами,
+// уже занятыми в used
+func (p *prevArchive) copyAll(aw ArchiveWriter, used map[string]struct{}) error {
+	if p.files != nil {
+		zw, ok := aw.(*zipWriter)
+		if !ok {
+			return errors.New("previous version format mismatch")
+		}
+		for _, f := range p.files {
+			renamed := *f
+			renamed.Name = uniqueName(used, f.Name)
+			if err := zw.zw.Copy(&renamed); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for {
+		hdr, err := p.tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		w, err := aw.Create(uniqueName(used, hdr.Name), hdr.ModTime)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, p.tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *prevArchive) Close() error {
+	if p.closer != nil {
+		p.closer()
+	}
+	return p.src.Close()
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingServer отдаёт путь запроса как содержимое файла и считает запросы
+func countingServer(t *testing.T) (*httptest.Server, func(path string) int) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Write([]byte(r.URL.Path))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[path]
+	}
+}
+
+func waitVersion(t *testing.T, mgr *TaskManager, id string, version int) *Task {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		task, _ := mgr.Status(id)
+		if task.Status == StatusComplete && task.Version == version {
+			return task
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("version %d not built", version)
+	return nil
+}
+
+func readVersion(t *testing.T, mgr *TaskManager, task *Task, format ArchiveFormat, n int) map[string]string {
+	t.Helper()
+	v, err := mgr.Version(task, n)
+	if err != nil {
+		t.Fatalf("version %d: %v", n, err)
+	}
+	r, err := mgr.openVersion(v)
+	if err != nil {
+		t.Fatalf("open version %d: %v", n, err)
+	}
+	defer r.Close()
+	data, _ := io.ReadAll(r)
+	return readArchive(t, format, data)
+}
+
+func TestReopenCompletedTask(t *testing.T) {
+	for _, format := range []ArchiveFormat{FormatZip, FormatTarGz} {
+		srv, hits := countingServer(t)
+		mgr := NewManager(2, 2, []string{".txt"})
+		id, _ := mgr.CreateWithOptions(TaskOptions{Format: format, MaxPartSize: minPartSize})
+		mgr.AddURL(id, srv.URL+"/a.txt")
+		mgr.AddURL(id, srv.URL+"/b.txt")
+		task := waitVersion(t, mgr, id, 1)
+
+		if err := mgr.AddURL(id, srv.URL+"/a.txt"); err == nil {
+			t.Fatalf("%s: expected duplicate error on reopen", format)
+		}
+		if err := mgr.AddURL(id, srv.URL+"/c.txt"); err != nil {
+			t.Fatalf("%s: reopen: %v", format, err)
+		}
+		if err := mgr.ForceZip(id); err != nil {
+			t.Fatalf("%s: force zip: %v", format, err)
+		}
+		task = waitVersion(t, mgr, id, 2)
+
+		files := readVersion(t, mgr, task, format, 2)
+		if len(files) != 3 || files["a.txt"] != "/a.txt" || files["c.txt"] != "/c.txt" {
+			t.Fatalf("%s: unexpected version 2 contents %v", format, files)
+		}
+		if hits("/a.txt") != 1 || hits("/b.txt") != 1 {
+			t.Fatalf("%s: existing files were downloaded again", format)
+		}
+		if files := readVersion(t, mgr, task, format, 1); len(files) != 2 {
+			t.Fatalf("%s: unexpected version 1 contents %v", format, files)
+		}
+		mgr.Delete(id)
+	}
+}
+
+func TestReopenKeepVersions(t *testing.T) {
+	srv, _ := countingServer(t)
+	mgr := NewManager(2, 1, []string{".txt"})
+	mgr.SetKeepVersions(1)
+	id, _ := mgr.Create()
+	for i, name := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		if err := mgr.AddURL(id, srv.URL+name); err != nil {
+			t.Fatalf("add %s: %v", name, err)
+		}
+		waitVersion(t, mgr, id, i+1)
+	}
+	task, _ := mgr.Status(id)
+	first := task.Versions[0]
+	if len(task.Versions) != 1 || first.Number != 2 {
+		t.Fatalf("expected only version 2 kept, got %+v", task.Versions)
+	}
+	if _, err := mgr.Version(task, 1); err == nil {
+		t.Fatal("expected version 1 to be removed")
+	}
+	zr, err := zip.OpenReader(task.ZipPath)
+	if err != nil {
+		t.Fatalf("open current: %v", err)
+	}
+	zr.Close()
+	if len(zr.File) != 3 {
+		t.Fatalf("expected 3 files in version 3, got %d", len(zr.File))
+	}
+	old := first.ZipPath
+	mgr.Delete(id)
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatal("expected previous version removed on delete")
+	}
+}
+
+func TestReopenCancelRestoresVersion(t *testing.T) {
+	srv, _ := countingServer(t)
+	mgr := NewManager(2, 2, []string{".txt"})
+	id, _ := mgr.Create()
+	mgr.AddURL(id, srv.URL+"/a.txt")
+	mgr.ForceZip(id)
+	waitVersion(t, mgr, id, 1)
+
+	mgr.AddURL(id, srv.URL+"/b.txt")
+	if err := mgr.Cancel(id); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	task, _ := mgr.Status(id)
+	if task.Status != StatusComplete || len(task.Urls) != 1 || task.Version != 1 {
+		t.Fatalf("expected version 1 restored, got %s %v", task.Status, task.Urls)
+	}
+	mgr.Delete(id)
+}
+
+func TestReopenBuildFailureRestoresVersion(t *testing.T) {
+	srv, _ := countingServer(t)
+	mgr := NewManager(2, 2, []string{".txt"})
+	id, _ := mgr.Create()
+	mgr.AddURL(id, srv.URL+"/a.txt")
+	mgr.ForceZip(id)
+	task := waitVersion(t, mgr, id, 1)
+	defer mgr.Delete(id)
+	digest := task.Digest
+
+	// прошлая версия пропала с диска: новую собрать не из чего
+	os.Remove(task.ZipPath)
+	mgr.AddURL(id, srv.URL+"/b.txt")
+	if err := mgr.ForceZip(id); err != nil {
+		t.Fatalf("force zip: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if task, _ = mgr.Status(id); task.Status != StatusProcessing {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if task.Status != StatusComplete || task.Version != 1 || len(task.Urls) != 1 || task.Failure == "" {
+		t.Fatalf("expected version 1 restored with failure, got %s v%d %v %q", task.Status, task.Version, task.Urls, task.Failure)
+	}
+	if !bytes.Equal(task.Digest, digest) || len(task.Versions) != 0 {
+		t.Fatalf("failed build replaced the current version")
+	}
+	if _, err := os.Stat(filepath.Join(os.TempDir(), id+"-v2.zip")); !os.IsNotExist(err) {
+		t.Fatal("expected parts of the failed version removed")
+	}
+}
+
+func TestReopenEncryptedTask(t *testing.T) {
+	srv, _ := countingServer(t)
+	mgr := NewManager(2, 1, []string{".txt"})
+	id, _ := mgr.CreateWithOptions(TaskOptions{Encrypt: true, Password: "secret"})
+	mgr.AddURL(id, srv.URL+"/a.txt")
+	waitVersion(t, mgr, id, 1)
+	if err := mgr.AddURL(id, srv.URL+"/b.txt"); err == nil || err.Error() != "encrypted task cannot be reopened" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	mgr.Delete(id)
+}
+
+func TestConcatReader(t *testing.T) {
+	dir := t.TempDir()
+	var parts []ArchivePart
+	for i, chunk := range []string{"hello ", "split ", "world"} {
+		path := dir + "/" + string(rune('a'+i))
+		os.WriteFile(path, []byte(chunk), 0o644)
+		parts = append(parts, ArchivePart{Path: path, Size: int64(len(chunk))})
+	}
+	mgr := NewManager(1, 1, nil)
+	r, err := mgr.openVersion(ArchiveVersion{Parts: parts})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+	buf := make([]byte, 9)
+	n, _ := (&readerAt{rs: r}).ReadAt(buf, 3)
+	if string(buf[:n]) != "lo split " {
+		t.Fatalf("unexpected ReadAt result %q", buf[:n])
+	}
+	r.Seek(0, io.SeekStart)
+	all, _ := io.ReadAll(r)
+	if !bytes.Equal(all, []byte("hello split world")) {
+		t.Fatalf("unexpected contents %q", all)
+	}
+}
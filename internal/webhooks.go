@@ -0,0 +1,491 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// События, о которых сообщают вебхуки
+const (
+	EventTaskCompleted = "task.completed"
+	EventTaskFailed    = "task.failed"
+	EventTaskCancelled = "task.cancelled"
+	EventTaskExpired   = "task.expired" // архивы задачи удалены: вместе с задачей или по сроку хранения версий
+)
+
+// Состояния доставки события
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Заголовки запроса с событием
+const (
+	headerWebhookEvent     = "X-Linkzipper-Event"
+	headerWebhookDelivery  = "X-Linkzipper-Delivery"
+	headerWebhookTimestamp = "X-Linkzipper-Timestamp"
+	headerWebhookSignature = "X-Linkzipper-Signature"
+)
+
+// webhookBackoff — пауза перед второй попыткой доставки, дальше она
+// удваивается до webhookMaxBackoff
+var (
+	webhookBackoff    = time.Second
+	webhookMaxBackoff = 10 * time.Minute
+)
+
+const (
+	defaultWebhookAttempts = 8
+	defaultWebhookTimeout  = 10 * time.Second
+)
+
+// Event — тело запроса вебхука
+type Event struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	TaskID     string            `json:"task_id"`
+	Status     TaskStatus        `json:"status"`
+	Version    int               `json:"version,omitempty"`
+	SHA256     string            `json:"sha256,omitempty"`
+	ArchiveURL string            `json:"archive_url,omitempty"`
+	Failure    string            `json:"failure,omitempty"`
+	Errors     map[string]string `json:"errors,omitempty"`
+	// ExpiredVersions — удалённые версии архива для task.expired, Deleted —
+	// задача удалена целиком
+	ExpiredVersions []int     `json:"expired_versions,omitempty"`
+	Deleted         bool      `json:"deleted,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// DeliveryAttempt — результат одной попытки доставки
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Delivery — доставка одного события на один адрес. Пока она не завершена,
+// её копия лежит в каталоге очереди и переживает перезапуск сервера
+type Delivery struct {
+	ID          string            `json:"id"`
+	TaskID      string            `json:"task_id"`
+	URL         string            `json:"url"`
+	Event       string            `json:"event"`
+	State       string            `json:"state"`
+	Attempts    []DeliveryAttempt `json:"attempts"`
+	NextAttempt *time.Time        `json:"next_attempt,omitempty"`
+	Payload     json.RawMessage   `json:"payload"`
+}
+
+type webhookSubscription struct {
+	url    string
+	events map[string]struct{}
+}
+
+// Webhooks подписывает события HMAC-SHA256 и доставляет их с повторами
+type Webhooks struct {
+	secret      []byte
+	dir         string
+	maxAttempts int
+	client      *http.Client
+	subs        []webhookSubscription
+
+	mu     sync.Mutex
+	byTask map[string][]*Delivery
+}
+
+// LoadWebhooks читает настройки вебхуков и возобновляет недоставленные
+// события из каталога очереди. Без секрета вебхуки выключены и возвращается nil
+func LoadWebhooks(cfg WebhooksConfig) (*Webhooks, error) {
+	secret := cfg.Secret
+	if cfg.SecretFile != "" {
+		data, err := os.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("read webhook secret: %v", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	if secret == "" {
+		if len(cfg.Subscriptions) > 0 {
+			return nil, errors.New("webhook subscriptions require a secret")
+		}
+		return nil, nil
+	}
+	subs := make([]webhookSubscription, 0, len(cfg.Subscriptions))
+	for _, s := range cfg.Subscriptions {
+		if err := checkCallbackURL(s.URL); err != nil {
+			return nil, fmt.Errorf("webhook subscription %s: %v", s.URL, err)
+		}
+		sub := webhookSubscription{url: s.URL}
+		if len(s.Events) > 0 {
+			sub.events = make(map[string]struct{}, len(s.Events))
+		}
+		for _, e := range s.Events {
+			switch e {
+			case EventTaskCompleted, EventTaskFailed, EventTaskCancelled, EventTaskExpired:
+				sub.events[e] = struct{}{}
+			default:
+				return nil, fmt.Errorf("webhook subscription %s: unknown event %s", s.URL, e)
+			}
+		}
+		subs = append(subs, sub)
+	}
+	timeout := defaultWebhookTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	return newWebhooks([]byte(secret), cfg.QueueDir, cfg.MaxAttempts, timeout, subs)
+}
+
+func newWebhooks(secret []byte, dir string, maxAttempts int, timeout time.Duration, subs []webhookSubscription) (*Webhooks, error) {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultWebhookAttempts
+	}
+	w := &Webhooks{
+		secret:      secret,
+		dir:         dir,
+		maxAttempts: maxAttempts,
+		client:      &http.Client{Timeout: timeout},
+		subs:        subs,
+		byTask:      make(map[string][]*Delivery),
+	}
+	if dir == "" {
+		Logger.Warn("webhook queue directory is not configured, pending deliveries are lost on restart")
+		return w, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		d := &Delivery{}
+		if err := json.Unmarshal(data, d); err != nil {
+			return nil, fmt.Errorf("read webhook queue %s: %v", path, err)
+		}
+		w.byTask[d.TaskID] = append(w.byTask[d.TaskID], d)
+		go w.deliver(d)
+	}
+	if len(files) > 0 {
+		Logger.WithField("deliveries", len(files)).Info("webhook deliveries resumed")
+	}
+	return w, nil
+}
+
+// SignWebhook возвращает подпись события для заголовка X-Linkzipper-Signature:
+// HMAC-SHA256 от строки "<timestamp>.<тело>" в hex
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkCallbackURL проверяет адрес, на который отправляются события
+func checkCallbackURL(url string) error {
+	parsed, err := neturl.Parse(url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return invalidf("invalid callback_url")
+	}
+	return nil
+}
+
+// Enqueue ставит событие в очередь на адрес задачи callbackURL и на все
+// подписки, которые его ожидают. Enqueue не ждёт диска и сети: доставка
+// сохраняется в каталог очереди уже в своей горутине
+func (w *Webhooks) Enqueue(ev Event, callbackURL string) {
+	var urls []string
+	if callbackURL != "" {
+		urls = append(urls, callbackURL)
+	}
+	for _, s := range w.subs {
+		if _, ok := s.events[ev.Type]; ok || s.events == nil {
+			urls = append(urls, s.url)
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+	ev.ID = "evt-" + randomID()
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		Logger.WithError(err).WithField("task_id", ev.TaskID).Error("webhook event encoding failed")
+		return
+	}
+	for _, url := range urls {
+		d := &Delivery{
+			ID:      "dlv-" + randomID(),
+			TaskID:  ev.TaskID,
+			URL:     url,
+			Event:   ev.Type,
+			State:   DeliveryPending,
+			Payload: payload,
+		}
+		data, _ := json.Marshal(d)
+		w.mu.Lock()
+		w.byTask[d.TaskID] = append(w.byTask[d.TaskID], d)
+		w.mu.Unlock()
+		Logger.WithFields(logrus.Fields{"task_id": ev.TaskID, "event": ev.Type, "delivery_id": d.ID}).Info("webhook queued")
+		go func() {
+			if err := w.persist(d.ID, data); err != nil {
+				Logger.WithError(err).WithField("delivery_id", d.ID).Error("webhook queue write failed")
+			}
+			w.deliver(d)
+		}()
+	}
+}
+
+// forget удаляет журнал доставок удалённой задачи. Начатые доставки
+// продолжаются, но в журнале больше не видны
+func (w *Webhooks) forget(taskID string) {
+	w.mu.Lock()
+	delete(w.byTask, taskID)
+	w.mu.Unlock()
+}
+
+// Deliveries возвращает журнал доставок событий задачи
+func (w *Webhooks) Deliveries(taskID string) []Delivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]Delivery, 0, len(w.byTask[taskID]))
+	for _, d := range w.byTask[taskID] {
+		c := *d
+		c.Attempts = append([]DeliveryAttempt(nil), d.Attempts...)
+		out = append(out, c)
+	}
+	return out
+}
+
+// deliver отправляет событие, пока адрес не ответит 2xx или не кончатся попытки
+func (w *Webhooks) deliver(d *Delivery) {
+	for {
+		w.mu.Lock()
+		next := d.NextAttempt
+		w.mu.Unlock()
+		if next != nil {
+			time.Sleep(time.Until(*next))
+		}
+
+		attempt := w.send(d)
+		w.mu.Lock()
+		d.Attempts = append(d.Attempts, attempt)
+		n := len(d.Attempts)
+		fields := logrus.Fields{"task_id": d.TaskID, "delivery_id": d.ID, "attempt": n}
+		switch {
+		case attempt.Error == "":
+			d.State = DeliveryDelivered
+			d.NextAttempt = nil
+		case n >= w.maxAttempts:
+			d.State = DeliveryFailed
+			d.NextAttempt = nil
+		default:
+			at := time.Now().Add(webhookDelay(n))
+			d.NextAttempt = &at
+		}
+		state := d.State
+		var data []byte
+		if state == DeliveryPending {
+			data, _ = json.Marshal(d)
+		}
+		w.mu.Unlock()
+		// файл доставки пишет только её горутина, поэтому w.mu на время записи не нужен
+		var err error
+		if state == DeliveryPending {
+			err = w.persist(d.ID, data)
+		} else {
+			err = w.unqueue(d.ID)
+		}
+		if err != nil {
+			Logger.WithError(err).WithFields(fields).Error("webhook queue write failed")
+		}
+
+		switch state {
+		case DeliveryDelivered:
+			Logger.WithFields(fields).Info("webhook delivered")
+			return
+		case DeliveryFailed:
+			Logger.WithFields(fields).Errorf("webhook delivery failed: %s", attempt.Error)
+			return
+		}
+		Logger.WithFields(fields).Warnf("webhook attempt failed: %s", attempt.Error)
+	}
+}
+
+// send делает одну попытку доставки с подписью на текущий момент
+func (w *Webhooks) send(d *Delivery) DeliveryAttempt {
+	attempt := DeliveryAttempt{At: time.Now()}
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := strconv.FormatInt(attempt.At.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerWebhookEvent, d.Event)
+	req.Header.Set(headerWebhookDelivery, d.ID)
+	req.Header.Set(headerWebhookTimestamp, timestamp)
+	req.Header.Set(headerWebhookSignature, SignWebhook(w.secret, timestamp, d.Payload))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = "unexpected status " + resp.Status
+	}
+	return attempt
+}
+
+// webhookDelay возвращает паузу после n неудачных попыток
+func webhookDelay(n int) time.Duration {
+	delay := webhookBackoff
+	for i := 1; i < n && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxBackoff {
+		delay = webhookMaxBackoff
+	}
+	return delay
+}
+
+// persist сохраняет незавершённую доставку id (data — её JSON) в каталог очереди
+func (w *Webhooks) persist(id string, data []byte) error {
+	if w.dir == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(w.dir, ".delivery-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(w.dir, id+".json"))
+}
+
+// unqueue убирает завершённую доставку id из каталога очереди
+func (w *Webhooks) unqueue(id string) error {
+	if w.dir == "" {
+		return nil
+	}
+	err := os.Remove(filepath.Join(w.dir, id+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SetWebhooks включает отправку событий о завершении задач
+func (m *TaskManager) SetWebhooks(w *Webhooks) {
+	m.mu.Lock()
+	m.webhooks = w
+	m.mu.Unlock()
+}
+
+// notify ставит в очередь событие о финальном состоянии задачи. Удалённые
+// задачи событий не шлют. Вызывается под m.mu: событие собирается из полей
+// задачи, а запись очереди на диск и отправка идут вне блокировки
+func (m *TaskManager) notify(task *Task) {
+	if m.webhooks == nil || task.discard {
+		return
+	}
+	ev := Event{TaskID: task.ID, Status: task.Status, CreatedAt: time.Now().UTC()}
+	switch task.Status {
+	case StatusComplete:
+		ev.Type = EventTaskCompleted
+		ev.Version = task.Version
+		ev.SHA256 = hex.EncodeToString(task.Digest)
+		if task.Options.MaxPartSize == 0 {
+			ev.ArchiveURL = APIPrefix + "/tasks/" + task.ID + "/archive"
+		}
+	case StatusFailed:
+		ev.Type = EventTaskFailed
+		ev.Failure = task.Failure
+	case StatusCancelled:
+		ev.Type = EventTaskCancelled
+	default:
+		return
+	}
+	if len(task.Errors) > 0 {
+		ev.Errors = make(map[string]string, len(task.Errors))
+		for k, v := range task.Errors {
+			ev.Errors[k] = v
+		}
+	}
+	m.webhooks.Enqueue(ev, task.Options.CallbackURL)
+}
+
+// notifyExpired ставит в очередь событие task.expired об удалённых версиях
+// архива; deleted означает, что удалена вся задача, и её журнал доставок
+// больше не нужен. Вызывается под m.mu
+func (m *TaskManager) notifyExpired(task *Task, versions []int, deleted bool) {
+	if m.webhooks == nil || (task.discard && !deleted) {
+		// об удалённой задаче уже сообщено при удалении
+		return
+	}
+	ev := Event{
+		Type:            EventTaskExpired,
+		TaskID:          task.ID,
+		Status:          task.Status,
+		Version:         task.Version,
+		ExpiredVersions: versions,
+		Deleted:         deleted,
+		CreatedAt:       time.Now().UTC(),
+	}
+	m.webhooks.Enqueue(ev, task.Options.CallbackURL)
+	if deleted {
+		m.webhooks.forget(task.ID)
+	}
+}
+
+// Deliveries возвращает журнал доставок событий задачи
+func (m *TaskManager) Deliveries(id string) ([]Delivery, error) {
+	if _, err := m.Status(id); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	w := m.webhooks
+	m.mu.Unlock()
+	if w == nil {
+		return []Delivery{}, nil
+	}
+	return w.Deliveries(id), nil
+}
@@ -0,0 +1,283 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver отвечает 500 на первые failures запросов и сохраняет
+// принятые события с проверенной подписью
+type webhookReceiver struct {
+	srv      *httptest.Server
+	mu       sync.Mutex
+	failures int
+	requests int
+	events   []Event
+}
+
+func newWebhookReceiver(t *testing.T, secret []byte, failures int) *webhookReceiver {
+	rcv := &webhookReceiver{failures: failures}
+	rcv.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.requests++
+		if rcv.requests <= rcv.failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get(headerWebhookSignature) != SignWebhook(secret, r.Header.Get(headerWebhookTimestamp), body) {
+			t.Errorf("bad webhook signature")
+		}
+		var ev Event
+		json.Unmarshal(body, &ev)
+		if ev.Type != r.Header.Get(headerWebhookEvent) {
+			t.Errorf("event header %s does not match %s", r.Header.Get(headerWebhookEvent), ev.Type)
+		}
+		rcv.events = append(rcv.events, ev)
+	}))
+	t.Cleanup(rcv.srv.Close)
+	return rcv
+}
+
+func (rcv *webhookReceiver) received() []Event {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]Event(nil), rcv.events...)
+}
+
+func setWebhookBackoff(t *testing.T, d time.Duration) {
+	old := webhookBackoff
+	webhookBackoff = d
+	t.Cleanup(func() { webhookBackoff = old })
+}
+
+func waitDeliveries(t *testing.T, mgr *TaskManager, id string, n int) []Delivery {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		deliveries, _ := mgr.Deliveries(id)
+		done := 0
+		for _, d := range deliveries {
+			if d.State != DeliveryPending {
+				done++
+			}
+		}
+		if len(deliveries) == n && done == n {
+			return deliveries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("deliveries for %s not finished", id)
+	return nil
+}
+
+func TestWebhookRetries(t *testing.T) {
+	setWebhookBackoff(t, 10*time.Millisecond)
+	secret := []byte("secret")
+	rcv := newWebhookReceiver(t, secret, 2)
+	srv, _ := countingServer(t)
+	dir := t.TempDir()
+	wh, err := newWebhooks(secret, dir, 5, time.Second, nil)
+	if err != nil {
+		t.Fatalf("webhooks: %v", err)
+	}
+	mgr := NewManager(2, 1, []string{".txt"})
+	mgr.SetWebhooks(wh)
+
+	id, err := mgr.CreateWithOptions(TaskOptions{CallbackURL: rcv.srv.URL})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	mgr.AddURL(id, srv.URL+"/a.txt")
+	deliveries := waitDeliveries(t, mgr, id, 1)
+
+	d := deliveries[0]
+	if d.State != DeliveryDelivered || len(d.Attempts) != 3 || d.Attempts[0].StatusCode != http.StatusInternalServerError {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+	events := rcv.received()
+	if len(events) != 1 || events[0].Type != EventTaskCompleted || events[0].TaskID != id || events[0].SHA256 == "" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 0 {
+		t.Fatalf("delivered event left in queue: %v", files)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	setWebhookBackoff(t, time.Millisecond)
+	rcv := newWebhookReceiver(t, []byte("secret"), 100)
+	wh, _ := newWebhooks([]byte("secret"), "", 3, time.Second, nil)
+	mgr := NewManager(2, 2, []string{".txt"})
+	mgr.SetWebhooks(wh)
+
+	id, _ := mgr.CreateWithOptions(TaskOptions{CallbackURL: rcv.srv.URL})
+	mgr.Cancel(id)
+	deliveries := waitDeliveries(t, mgr, id, 1)
+	if d := deliveries[0]; d.State != DeliveryFailed || len(d.Attempts) != 3 || d.Event != EventTaskCancelled {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	secret := []byte("secret")
+	all := newWebhookReceiver(t, secret, 0)
+	cancelled := newWebhookReceiver(t, secret, 0)
+	wh, err := LoadWebhooks(WebhooksConfig{
+		Secret: string(secret),
+		Subscriptions: []WebhookSubscriptionConfig{
+			{URL: all.srv.URL},
+			{URL: cancelled.srv.URL, Events: []string{EventTaskCancelled}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("load webhooks: %v", err)
+	}
+	srv, _ := countingServer(t)
+	mgr := NewManager(2, 1, []string{".txt"})
+	mgr.SetWebhooks(wh)
+
+	done, _ := mgr.Create()
+	mgr.AddURL(done, srv.URL+"/a.txt")
+	waitDeliveries(t, mgr, done, 1)
+	stopped, _ := mgr.Create()
+	mgr.Cancel(stopped)
+	waitDeliveries(t, mgr, stopped, 2)
+
+	if events := all.received(); len(events) != 2 {
+		t.Fatalf("expected both events for catch-all subscription, got %+v", events)
+	}
+	if events := cancelled.received(); len(events) != 1 || events[0].TaskID != stopped {
+		t.Fatalf("expected only cancellation, got %+v", events)
+	}
+
+	if _, err := LoadWebhooks(WebhooksConfig{Subscriptions: []WebhookSubscriptionConfig{{URL: all.srv.URL}}}); err == nil {
+		t.Fatal("expected error for subscriptions without secret")
+	}
+	if _, err := LoadWebhooks(WebhooksConfig{Secret: "s", Subscriptions: []WebhookSubscriptionConfig{{URL: all.srv.URL, Events: []string{"task.deleted"}}}}); err == nil {
+		t.Fatal("expected error for unknown event")
+	}
+}
+
+func TestWebhookExpiry(t *testing.T) {
+	secret := []byte("secret")
+	rcv := newWebhookReceiver(t, secret, 0)
+	wh, _ := newWebhooks(secret, "", 3, time.Second, []webhookSubscription{
+		{url: rcv.srv.URL, events: map[string]struct{}{EventTaskExpired: {}}},
+	})
+	srv, _ := countingServer(t)
+	mgr := NewManager(2, 1, []string{".txt"})
+	mgr.SetKeepVersions(1)
+	mgr.SetWebhooks(wh)
+
+	id, _ := mgr.Create()
+	for i, name := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		mgr.AddURL(id, srv.URL+name)
+		waitVersion(t, mgr, id, i+1)
+	}
+	mgr.Delete(id)
+	for i := 0; i < 200 && len(rcv.received()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	events := rcv.received()
+	if len(events) != 2 {
+		t.Fatalf("expected two expiry events, got %+v", events)
+	}
+	pruned, deleted := events[0], events[1]
+	if pruned.Deleted {
+		pruned, deleted = deleted, pruned
+	}
+	if pruned.Deleted || len(pruned.ExpiredVersions) != 1 || pruned.ExpiredVersions[0] != 1 {
+		t.Fatalf("unexpected pruning event %+v", pruned)
+	}
+	if !deleted.Deleted || len(deleted.ExpiredVersions) != 2 || deleted.ExpiredVersions[1] != 3 {
+		t.Fatalf("unexpected deletion event %+v", deleted)
+	}
+	if d := wh.Deliveries(id); len(d) != 0 {
+		t.Fatalf("delivery log kept for deleted task: %+v", d)
+	}
+}
+
+func TestWebhookQueueResume(t *testing.T) {
+	secret := []byte("secret")
+	rcv := newWebhookReceiver(t, secret, 0)
+	dir := t.TempDir()
+	pending := Delivery{
+		ID:      "dlv-1",
+		TaskID:  "task-1",
+		URL:     rcv.srv.URL,
+		Event:   EventTaskFailed,
+		State:   DeliveryPending,
+		Payload: json.RawMessage(`{"id":"evt-1","type":"task.failed","task_id":"task-1","status":"failed"}`),
+	}
+	data, _ := json.Marshal(pending)
+	os.WriteFile(filepath.Join(dir, "dlv-1.json"), data, 0o600)
+
+	wh, err := newWebhooks(secret, dir, 3, time.Second, nil)
+	if err != nil {
+		t.Fatalf("webhooks: %v", err)
+	}
+	for i := 0; i < 200 && len(rcv.received()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if events := rcv.received(); len(events) != 1 || events[0].ID != "evt-1" {
+		t.Fatalf("queued event not resumed: %+v", events)
+	}
+	for i := 0; i < 200; i++ {
+		if d := wh.Deliveries("task-1"); len(d) == 1 && d[0].State == DeliveryDelivered {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("resumed delivery not marked delivered")
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	ts, mgr := setupTestServer()
+	defer ts.Close()
+
+	body := `{"callback_url": "http://example.com/hook"}`
+	resp, _ := http.Post(ts.URL+"/api/v1/tasks", "application/json", strings.NewReader(body))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 without webhooks config, got %d", resp.StatusCode)
+	}
+
+	rcv := newWebhookReceiver(t, []byte("secret"), 0)
+	wh, _ := newWebhooks([]byte("secret"), "", 3, time.Second, nil)
+	mgr.SetWebhooks(wh)
+	body = `{"callback_url": "` + rcv.srv.URL + `"}`
+	resp, _ = http.Post(ts.URL+"/api/v1/tasks", "application/json", strings.NewReader(body))
+	var created map[string]string
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/tasks/"+created["task_id"]+"/cancel", nil)
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	waitDeliveries(t, mgr, created["task_id"], 1)
+
+	resp, err := http.Get(ts.URL + "/api/v1/tasks/" + created["task_id"] + "/deliveries")
+	if err != nil {
+		t.Fatalf("deliveries: %v", err)
+	}
+	var deliveries []Delivery
+	json.NewDecoder(resp.Body).Decode(&deliveries)
+	resp.Body.Close()
+	if len(deliveries) != 1 || deliveries[0].State != DeliveryDelivered || deliveries[0].URL != rcv.srv.URL {
+		t.Fatalf("unexpected deliveries %+v", deliveries)
+	}
+
+	resp, _ = http.Get(ts.URL + "/api/v1/tasks/missing/deliveries")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown task, got %d", resp.StatusCode)
+	}
+}
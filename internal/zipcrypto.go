@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"io"
+)
+
+// Шифрование записей zip по спецификации WinZip AES (AE-1, AES-256)
+const (
+	methodWinZipAES = 99
+	aesExtraID      = 0x9901
+	aesVendorAE1    = 1
+	aesStrength256  = 3
+	aesSaltLen      = 16
+	aesKeyLen       = 32
+	aesVerifierLen  = 2
+	aesMACLen       = 10
+	aesIterations   = 1000
+)
+
+// aesEntryKey — ключи одной записи архива, выведенные из пароля со своей солью
+type aesEntryKey struct {
+	salt     []byte
+	encKey   []byte
+	macKey   []byte
+	verifier []byte
+}
+
+// GeneratePassword создаёт случайный пароль для архива
+func GeneratePassword() string {
+	b := make([]byte, 18)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func deriveAESKey(password string) (aesEntryKey, error) {
+	salt := make([]byte, aesSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return aesEntryKey{}, err
+	}
+	return deriveAESKeyWithSalt(password, salt)
+}
+
+func deriveAESKeyWithSalt(password string, salt []byte) (aesEntryKey, error) {
+	dk, err := pbkdf2.Key(sha1.New, password, salt, aesIterations, 2*aesKeyLen+aesVerifierLen)
+	if err != nil {
+		return aesEntryKey{}, err
+	}
+	return aesEntryKey{
+		salt:     salt,
+		encKey:   dk[:aesKeyLen],
+		macKey:   dk[aesKeyLen : 2*aesKeyLen],
+		verifier: dk[2*aesKeyLen:],
+	}, nil
+}
+
+// aesExtra формирует дополнительное поле 0x9901 с исходным методом сжатия
+func aesExtra(method uint16) []byte {
+	b := make([]byte, 11)
+	binary.LittleEndian.PutUint16(b[0:], aesExtraID)
+	binary.LittleEndian.PutUint16(b[2:], 7)
+	binary.LittleEndian.PutUint16(b[4:], aesVendorAE1)
+	copy(b[6:], "AE")
+	b[8] = aesStrength256
+	binary.LittleEndian.PutUint16(b[9:], method)
+	return b
+}
+
+// winzipCTR — AES-CTR с little-endian счётчиком, начинающимся с 1,
+// как требует WinZip; cipher.NewCTR увеличивает счётчик в big-endian
+type winzipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	pos     int
+}
+
+func newWinzipCTR(key []byte) (*winzipCTR, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &winzipCTR{block: block, pos: aes.BlockSize}, nil
+}
+
+func (c *winzipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.pos == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.pos = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.pos]
+		c.pos++
+	}
+}
+
+// aesWriter шифрует данные записи: пишет соль и проверочное значение,
+// затем шифротекст и при закрытии — код аутентификации
+type aesWriter struct {
+	w      io.Writer
+	ctr    *winzipCTR
+	mac    hash.Hash
+	buf    []byte
+	header bool
+	key    aesEntryKey
+}
+
+func newAESWriter(w io.Writer, key aesEntryKey) (*aesWriter, error) {
+	ctr, err := newWinzipCTR(key.encKey)
+	if err != nil {
+		return nil, err
+	}
+	return &aesWriter{w: w, ctr: ctr, mac: hmac.New(sha1.New, key.macKey), key: key}, nil
+}
+
+func (a *aesWriter) Write(p []byte) (int, error) {
+	if !a.header {
+		a.header = true
+		if _, err := a.w.Write(append(append([]byte{}, a.key.salt...), a.key.verifier...)); err != nil {
+			return 0, err
+		}
+	}
+	if cap(a.buf) < len(p) {
+		a.buf = make([]byte, len(p))
+	}
+	out := a.buf[:len(p)]
+	a.ctr.XORKeyStream(out, p)
+	a.mac.Write(out)
+	return a.w.Write(out)
+}
+
+func (a *aesWriter) Close() error {
+	if !a.header {
+		if _, err := a.Write(nil); err != nil {
+			return err
+		}
+	}
+	_, err := a.w.Write(a.mac.Sum(nil)[:aesMACLen])
+	return err
+}
+
+// aesCompressor сжимает (при необходимости) и шифрует данные записи
+type aesCompressor struct {
+	comp io.WriteCloser
+	enc  *aesWriter
+}
+
+func newAESCompressor(w io.Writer, key aesEntryKey, method uint16, level int) (io.WriteCloser, error) {
+	enc, err := newAESWriter(w, key)
+	if err != nil {
+		return nil, err
+	}
+	c := &aesCompressor{enc: enc}
+	if method != 0 {
+		fw, err := flate.NewWriter(enc, level)
+		if err != nil {
+			return nil, err
+		}
+		c.comp = fw
+	}
+	return c, nil
+}
+
+func (c *aesCompressor) Write(p []byte) (int, error) {
+	if c.comp != nil {
+		return c.comp.Write(p)
+	}
+	return c.enc.Write(p)
+}
+
+func (c *aesCompressor) Close() error {
+	if c.comp != nil {
+		if err := c.comp.Close(); err != nil {
+			return err
+		}
+	}
+	return c.enc.Close()
+}
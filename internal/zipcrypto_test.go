@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+	"time"
+)
+
+// decryptAESEntry расшифровывает запись WinZip AES для проверки результата
+func decryptAESEntry(t *testing.T, f *zip.File, password string) []byte {
+	t.Helper()
+	if f.Method != methodWinZipAES || f.Flags&0x1 == 0 {
+		t.Fatalf("%s: entry is not AES encrypted", f.Name)
+	}
+	extra := f.Extra
+	var method uint16
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := binary.LittleEndian.Uint16(extra[2:])
+		if id == aesExtraID {
+			if string(extra[6:8]) != "AE" || extra[8] != aesStrength256 {
+				t.Fatalf("%s: unexpected AES extra field", f.Name)
+			}
+			method = binary.LittleEndian.Uint16(extra[9:])
+		}
+		extra = extra[4+size:]
+	}
+	rc, err := f.OpenRaw()
+	if err != nil {
+		t.Fatalf("open raw: %v", err)
+	}
+	raw, _ := io.ReadAll(rc)
+	salt := raw[:aesSaltLen]
+	verifier := raw[aesSaltLen : aesSaltLen+aesVerifierLen]
+	data := raw[aesSaltLen+aesVerifierLen : len(raw)-aesMACLen]
+	mac := raw[len(raw)-aesMACLen:]
+
+	key, err := deriveAESKeyWithSalt(password, salt)
+	if err != nil {
+		t.Fatalf("derive key: %v", err)
+	}
+	if !bytes.Equal(verifier, key.verifier) {
+		t.Fatalf("%s: password verifier mismatch", f.Name)
+	}
+	h := hmac.New(sha1.New, key.macKey)
+	h.Write(data)
+	if !bytes.Equal(h.Sum(nil)[:aesMACLen], mac) {
+		t.Fatalf("%s: authentication code mismatch", f.Name)
+	}
+	ctr, _ := newWinzipCTR(key.encKey)
+	plain := make([]byte, len(data))
+	ctr.XORKeyStream(plain, data)
+	if method == zip.Deflate {
+		plain, err = io.ReadAll(flate.NewReader(bytes.NewReader(plain)))
+		if err != nil {
+			t.Fatalf("inflate: %v", err)
+		}
+	}
+	if crc32.ChecksumIEEE(plain) != f.CRC32 {
+		t.Fatalf("%s: crc mismatch", f.Name)
+	}
+	return plain
+}
+
+func TestWinzipCTRCounter(t *testing.T) {
+	key := make([]byte, aesKeyLen)
+	ctr, _ := newWinzipCTR(key)
+	out := make([]byte, 32)
+	ctr.XORKeyStream(out, make([]byte, 32))
+
+	var counter [16]byte
+	want := make([]byte, 32)
+	counter[0] = 1
+	ctr.block.Encrypt(want[:16], counter[:])
+	counter[0] = 2
+	ctr.block.Encrypt(want[16:], counter[:])
+	if !bytes.Equal(out, want) {
+		t.Fatal("keystream does not use little-endian counter starting at 1")
+	}
+}
+
+func TestEncryptedZip(t *testing.T) {
+	const password = "secret"
+	autoStore := true
+	var buf bytes.Buffer
+	aw, err := newArchiveWriter(&buf, TaskOptions{Format: FormatZip, AutoStore: &autoStore},
+		map[string]struct{}{".jpeg": {}}, password)
+	if err != nil {
+		t.Fatalf("new writer: %v", err)
+	}
+	payload := bytes.Repeat([]byte("secret data "), 100)
+	w, _ := aw.Create("doc.txt", time.Now())
+	w.Write(payload)
+	w, _ = aw.Create("photo.jpeg", time.Now())
+	w.Write([]byte("jpeg"))
+	aw.Close()
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret data")) {
+		t.Fatal("archive contains plaintext")
+	}
+	if got := decryptAESEntry(t, zr.File[0], password); !bytes.Equal(got, payload) {
+		t.Fatal("unexpected decrypted payload")
+	}
+	if got := decryptAESEntry(t, zr.File[1], password); string(got) != "jpeg" {
+		t.Fatalf("unexpected decrypted payload %q", got)
+	}
+	salts := make(map[string]bool)
+	for _, f := range zr.File {
+		raw, _ := f.OpenRaw()
+		salt := make([]byte, aesSaltLen)
+		io.ReadFull(raw, salt)
+		salts[string(salt)] = true
+	}
+	if len(salts) != len(zr.File) {
+		t.Fatal("entries share an encryption salt")
+	}
+}